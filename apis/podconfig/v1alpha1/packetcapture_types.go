@@ -0,0 +1,179 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Packet capture defaults and limits
+const (
+	DefaultCaptureDurationSeconds = 60
+	DefaultCaptureSnapLength      = 262144
+)
+
+var (
+	// DefaultCaptureMaxSize of the pcap file when none is given
+	DefaultCaptureMaxSize = resource.MustParse("10Mi")
+	// MaxCaptureSize any pcap file may grow to
+	MaxCaptureSize = resource.MustParse("1Gi")
+)
+
+// AttachmentRef names a network attachment configured on the pod by a podconfig
+type AttachmentRef struct {
+	// Name of the podconfig in the namespace of the capture
+	PodConfig string `json:"podConfig"`
+
+	// Name of the network attachment link in the podconfig
+	Name string `json:"name"`
+}
+
+// CaptureOutput type for a pcap file written into a container of the pod
+type CaptureOutput struct {
+	// Container of the pod the file is written from, usually one mounting
+	// a persistent volume
+	Container string `json:"container"`
+
+	// Absolute path of the new file in the container, on a volume of the pod
+	Path string `json:"path"`
+}
+
+// PacketCaptureSpec defines a capture of the traffic of a pod
+type PacketCaptureSpec struct {
+	// Pod of the namespace whose network namespace is captured
+	PodName string `json:"podName"`
+
+	// Attachment whose pod interface is captured. All interfaces of the
+	// pod are captured when not set.
+	Attachment *AttachmentRef `json:"attachment,omitempty"`
+
+	// Filter expression in tcpdump syntax, compiled to classic BPF and
+	// attached to the capture socket. All packets are captured when empty.
+	Filter string `json:"filter,omitempty"`
+
+	// Seconds the capture runs, defaults to 60
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3600
+	DurationSeconds *int32 `json:"durationSeconds,omitempty"`
+
+	// Size the pcap file may grow to before the capture stops, defaults to 10Mi
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+
+	// Bytes kept of every packet, defaults to 262144
+	// +kubebuilder:validation:Minimum=64
+	// +kubebuilder:validation:Maximum=262144
+	SnapLength *int32 `json:"snapLength,omitempty"`
+
+	// Container file the pcap is written to
+	Output CaptureOutput `json:"output"`
+}
+
+// PacketCapturePhase type for status
+type PacketCapturePhase string
+
+// Packet capture phases, a capture is run at most once
+const (
+	PacketCapturePending   PacketCapturePhase = ""
+	PacketCaptureRunning   PacketCapturePhase = "Running"
+	PacketCaptureSucceeded PacketCapturePhase = "Succeeded"
+	PacketCaptureFailed    PacketCapturePhase = "Failed"
+)
+
+// PacketCaptureStatus defines the observed state of PacketCapture
+type PacketCaptureStatus struct {
+	Phase PacketCapturePhase `json:"phase,omitempty"`
+
+	// Why the capture stopped or failed
+	Message string `json:"message,omitempty"`
+
+	// Node the capture ran on
+	Node string `json:"node,omitempty"`
+
+	// Pod interface captured, any for all of them
+	Interface string `json:"interface,omitempty"`
+
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Packets written to the pcap file
+	Packets int64 `json:"packets,omitempty"`
+
+	// Packets dropped by the kernel since the socket buffer was full
+	Dropped int64 `json:"dropped,omitempty"`
+
+	// Size of the pcap file
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Path of the pcap file in the output container
+	File string `json:"file,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Pod",type=string,JSONPath=`.spec.podName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Packets",type=integer,JSONPath=`.status.packets`
+// +kubebuilder:printcolumn:name="File",type=string,JSONPath=`.status.file`
+
+// PacketCapture is the Schema for the packetcaptures API
+type PacketCapture struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PacketCaptureSpec   `json:"spec"`
+	Status PacketCaptureStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PacketCaptureList contains a list of PacketCapture
+type PacketCaptureList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PacketCapture `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PacketCapture{}, &PacketCaptureList{})
+}
+
+// Duration of the capture
+func (p *PacketCapture) Duration() time.Duration {
+	if p.Spec.DurationSeconds != nil {
+		return time.Duration(*p.Spec.DurationSeconds) * time.Second
+	}
+	return DefaultCaptureDurationSeconds * time.Second
+}
+
+// MaxBytes the pcap file may grow to
+func (p *PacketCapture) MaxBytes() int64 {
+	if p.Spec.MaxSize != nil {
+		return p.Spec.MaxSize.Value()
+	}
+	return DefaultCaptureMaxSize.Value()
+}
+
+// SnapLength kept of every packet
+func (p *PacketCapture) SnapLength() int {
+	if p.Spec.SnapLength != nil {
+		return int(*p.Spec.SnapLength)
+	}
+	return DefaultCaptureSnapLength
+}
@@ -0,0 +1,91 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var packetcapturelog = logf.Log.WithName("packetcapture-resource")
+
+// SetupWebhookWithManager registers the packet capture webhook with the manager
+func (r *PacketCapture) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-podconfig-opdev-io-v1alpha1-packetcapture,mutating=false,failurePolicy=fail,groups=podconfig.opdev.io,resources=packetcaptures,versions=v1alpha1,name=vpacketcapture.kb.io
+
+var _ webhook.Validator = &PacketCapture{}
+
+// ValidateCreate checks the capture limits and output
+func (r *PacketCapture) ValidateCreate() error {
+	packetcapturelog.Info("validate create", "name", r.Name)
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.MaxSize != nil && (r.Spec.MaxSize.Sign() <= 0 || r.Spec.MaxSize.Cmp(MaxCaptureSize) > 0) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("maxSize"), r.Spec.MaxSize.String(),
+			fmt.Sprintf("must be positive and at most %s", MaxCaptureSize.String())))
+	}
+
+	if r.Spec.Output.Container == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("output", "container"), "container name is required"))
+	}
+	path := r.Spec.Output.Path
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || path == "/" {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("output", "path"), path,
+			"must be a clean absolute file path"))
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "PacketCapture"},
+		r.Name, allErrs)
+}
+
+// ValidateUpdate keeps the spec of a packet capture as it was created
+func (r *PacketCapture) ValidateUpdate(old runtime.Object) error {
+	packetcapturelog.Info("validate update", "name", r.Name)
+
+	if equality.Semantic.DeepEqual(old.(*PacketCapture).Spec, r.Spec) {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "PacketCapture"},
+		r.Name, field.ErrorList{field.Forbidden(field.NewPath("spec"), "packet capture spec can't be changed")})
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *PacketCapture) ValidateDelete() error {
+	return nil
+}
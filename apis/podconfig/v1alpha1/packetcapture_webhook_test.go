@@ -0,0 +1,74 @@
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Fields of the causes of an invalid error
+func invalidFields(err error) []string {
+
+	fields := []string{}
+	if err == nil {
+		return fields
+	}
+	Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	for _, cause := range err.(*apierrors.StatusError).ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	return fields
+}
+
+var _ = Describe("PacketCapture webhook", func() {
+
+	newCapture := func() *PacketCapture {
+		return &PacketCapture{Spec: PacketCaptureSpec{
+			PodName: "cnf",
+			Output:  CaptureOutput{Container: "cnf", Path: "/data/capture.pcap"},
+		}}
+	}
+
+	size := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+
+	DescribeTable("capture checks",
+		func(modify func(capture *PacketCapture), fields ...string) {
+			capture := newCapture()
+			if modify != nil {
+				modify(capture)
+			}
+			Expect(invalidFields(capture.ValidateCreate())).To(ConsistOf(fields))
+		},
+		Entry("accepts a valid capture", nil),
+		Entry("accepts the largest size", func(c *PacketCapture) { c.Spec.MaxSize = size("1Gi") }),
+		Entry("rejects larger sizes", func(c *PacketCapture) { c.Spec.MaxSize = size("2Gi") },
+			"spec.maxSize"),
+		Entry("rejects a zero size", func(c *PacketCapture) { c.Spec.MaxSize = size("0") },
+			"spec.maxSize"),
+		Entry("requires an output container", func(c *PacketCapture) { c.Spec.Output.Container = "" },
+			"spec.output.container"),
+		Entry("requires an absolute output path", func(c *PacketCapture) { c.Spec.Output.Path = "data/capture.pcap" },
+			"spec.output.path"),
+		Entry("requires a clean output path", func(c *PacketCapture) { c.Spec.Output.Path = "/data/../etc/capture.pcap" },
+			"spec.output.path"),
+		Entry("refuses the root as output path", func(c *PacketCapture) { c.Spec.Output.Path = "/" },
+			"spec.output.path"),
+	)
+
+	It("lets metadata updates through but no spec change", func() {
+		old := newCapture()
+
+		unchanged := old.DeepCopy()
+		unchanged.Labels = map[string]string{"app": "cnf"}
+		Expect(unchanged.ValidateUpdate(old)).To(Succeed())
+
+		changed := old.DeepCopy()
+		changed.Spec.Filter = "icmp"
+		Expect(invalidFields(changed.ValidateUpdate(old))).To(ConsistOf("spec"))
+	})
+})
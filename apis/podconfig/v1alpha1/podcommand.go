@@ -0,0 +1,156 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultCommandTimeoutSeconds is the timeout of templates that don't set one
+const DefaultCommandTimeoutSeconds = 30
+
+// TemplateViolations checks a pod command against its template and the
+// namespace selector of the template. The template is returned when found.
+func TemplateViolations(ctx context.Context, c client.Reader, podCommand *PodCommand) (*PodCommandTemplate, field.ErrorList, error) {
+
+	var allErrs field.ErrorList
+
+	template := &PodCommandTemplate{}
+	err := c.Get(ctx, client.ObjectKey{Name: podCommand.Spec.Template}, template)
+	if apierrors.IsNotFound(err) {
+		return nil, append(allErrs, field.NotFound(field.NewPath("spec", "template"), podCommand.Spec.Template)), nil
+	}
+	if err != nil {
+		return nil, allErrs, fmt.Errorf("failed to get pod command template %s: %w", podCommand.Spec.Template, err)
+	}
+
+	namespace := &corev1.Namespace{}
+	err = c.Get(ctx, client.ObjectKey{Name: podCommand.Namespace}, namespace)
+	if err != nil {
+		return nil, allErrs, fmt.Errorf("failed to get namespace %s: %w", podCommand.Namespace, err)
+	}
+	selected, err := template.Selects(namespace)
+	if err != nil {
+		return nil, allErrs, err
+	}
+	if !selected {
+		return template, append(allErrs, field.Forbidden(field.NewPath("spec", "template"),
+			fmt.Sprintf("template %s is not allowed in namespace %s", template.Name, podCommand.Namespace))), nil
+	}
+
+	return template, template.Check(podCommand), nil
+}
+
+// Selects tells whether pod commands of the namespace may use the template
+func (t *PodCommandTemplate) Selects(namespace *corev1.Namespace) (bool, error) {
+
+	if t.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(t.Spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespace selector in pod command template %s: %v", t.Name, err)
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// Namespaces pod commands can be run in, templates created with the mount
+// namespace before it was refused are not run
+var commandNamespaces = map[CommandNamespace]bool{
+	CommandNamespaceNet: true,
+	CommandNamespaceUTS: true,
+	CommandNamespaceIPC: true,
+	CommandNamespacePID: true,
+}
+
+// Check returns the pod command fields not allowed by the template
+func (t *PodCommandTemplate) Check(podCommand *PodCommand) field.ErrorList {
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	for _, namespace := range t.EnteredNamespaces() {
+		if !commandNamespaces[namespace] {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("template"),
+				fmt.Sprintf("template %s enters the %s namespace, which is not allowed", t.Name, namespace)))
+		}
+	}
+
+	maxArgs := 0
+	if t.Spec.Arguments != nil {
+		maxArgs = int(t.Spec.Arguments.MaxCount)
+	}
+	if len(podCommand.Spec.Args) > maxArgs {
+		allErrs = append(allErrs, field.TooMany(specPath.Child("args"), len(podCommand.Spec.Args), maxArgs))
+	}
+
+	if t.Spec.Arguments != nil && t.Spec.Arguments.Pattern != "" {
+		pattern, err := regexp.Compile("^(?:" + t.Spec.Arguments.Pattern + ")$")
+		if err != nil {
+			return append(allErrs, field.Forbidden(specPath.Child("args"),
+				fmt.Sprintf("template %s has an invalid argument pattern: %v", t.Name, err)))
+		}
+		for i, arg := range podCommand.Spec.Args {
+			if !pattern.MatchString(arg) {
+				allErrs = append(allErrs, field.Invalid(specPath.Child("args").Index(i), arg,
+					fmt.Sprintf("must match %s", t.Spec.Arguments.Pattern)))
+			}
+		}
+	}
+
+	if timeout := podCommand.Spec.TimeoutSeconds; timeout != nil {
+		if *timeout < 1 || *timeout > t.timeoutSeconds() {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("timeoutSeconds"), *timeout,
+				fmt.Sprintf("must be between 1 and %d", t.timeoutSeconds())))
+		}
+	}
+
+	return allErrs
+}
+
+func (t *PodCommandTemplate) timeoutSeconds() int32 {
+	if t.Spec.TimeoutSeconds != nil {
+		return *t.Spec.TimeoutSeconds
+	}
+	return DefaultCommandTimeoutSeconds
+}
+
+// Timeout of a pod command run from the template
+func (t *PodCommandTemplate) Timeout(podCommand *PodCommand) time.Duration {
+	if podCommand.Spec.TimeoutSeconds != nil {
+		return time.Duration(*podCommand.Spec.TimeoutSeconds) * time.Second
+	}
+	return time.Duration(t.timeoutSeconds()) * time.Second
+}
+
+// EnteredNamespaces of the pod, only net when the template lists none
+func (t *PodCommandTemplate) EnteredNamespaces() []CommandNamespace {
+	if len(t.Spec.Namespaces) == 0 {
+		return []CommandNamespace{CommandNamespaceNet}
+	}
+	return t.Spec.Namespaces
+}
@@ -0,0 +1,64 @@
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("PodCommandTemplate", func() {
+
+	ten := int32(10)
+	seconds := func(s int32) *int32 { return &s }
+
+	newTemplate := func(namespaces ...CommandNamespace) *PodCommandTemplate {
+		return &PodCommandTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "ping"},
+			Spec: PodCommandTemplateSpec{
+				Command:        []string{"ping", "-c", "3"},
+				Namespaces:     namespaces,
+				Arguments:      &CommandArguments{MaxCount: 1, Pattern: `[0-9.]+`},
+				TimeoutSeconds: &ten,
+			},
+		}
+	}
+
+	newCommand := func(timeout *int32, args ...string) *PodCommand {
+		return &PodCommand{Spec: PodCommandSpec{Template: "ping", PodName: "cnf", Args: args, TimeoutSeconds: timeout}}
+	}
+
+	DescribeTable("command checks",
+		func(template *PodCommandTemplate, command *PodCommand, fields ...string) {
+			Expect(errorFields(template.Check(command))).To(ConsistOf(fields))
+		},
+		Entry("accepts an allowed command", newTemplate(), newCommand(nil, "192.168.100.1")),
+		Entry("accepts the net, uts, ipc and pid namespaces",
+			newTemplate(CommandNamespaceNet, CommandNamespaceUTS, CommandNamespaceIPC, CommandNamespacePID), newCommand(nil)),
+		Entry("refuses templates entering the mount namespace", newTemplate(CommandNamespaceNet, "mnt"), newCommand(nil),
+			"spec.template"),
+		Entry("rejects too many arguments", newTemplate(), newCommand(nil, "192.168.100.1", "192.168.100.2"),
+			"spec.args"),
+		Entry("rejects arguments not matching the whole pattern", newTemplate(), newCommand(nil, "192.168.100.1; reboot"),
+			"spec.args[0]"),
+		Entry("rejects timeouts above the template one", newTemplate(), newCommand(seconds(11)),
+			"spec.timeoutSeconds"),
+		Entry("rejects a zero timeout", newTemplate(), newCommand(seconds(0)),
+			"spec.timeoutSeconds"),
+		Entry("rejects arguments when the template takes none",
+			&PodCommandTemplate{Spec: PodCommandTemplateSpec{Command: []string{"ip", "link"}}}, newCommand(nil, "show"),
+			"spec.args"),
+	)
+
+	DescribeTable("timeouts",
+		func(template *int32, command *int32, timeout time.Duration) {
+			t := &PodCommandTemplate{Spec: PodCommandTemplateSpec{TimeoutSeconds: template}}
+			Expect(t.Timeout(&PodCommand{Spec: PodCommandSpec{TimeoutSeconds: command}})).To(Equal(timeout))
+		},
+		Entry("default to a fixed timeout", nil, nil, DefaultCommandTimeoutSeconds*time.Second),
+		Entry("follow the template", &ten, nil, 10*time.Second),
+		Entry("are shortened by the command", &ten, seconds(5), 5*time.Second),
+	)
+})
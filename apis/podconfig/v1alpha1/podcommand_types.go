@@ -0,0 +1,104 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodCommandSpec defines the run of a command template on behalf of a pod.
+// The spec can't be changed once created.
+type PodCommandSpec struct {
+	// Name of the PodCommandTemplate to run
+	Template string `json:"template"`
+
+	// Pod of the namespace the command runs for
+	PodName string `json:"podName"`
+
+	// Container whose uts, ipc and pid namespaces are entered,
+	// the first running container when empty
+	Container string `json:"container,omitempty"`
+
+	// Appended to the template command
+	Args []string `json:"args,omitempty"`
+
+	// Shorter timeout than the template one
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// PodCommandPhase type for status
+type PodCommandPhase string
+
+// Pod command phases, a command is run at most once
+const (
+	PodCommandPending   PodCommandPhase = ""
+	PodCommandRunning   PodCommandPhase = "Running"
+	PodCommandSucceeded PodCommandPhase = "Succeeded"
+	PodCommandFailed    PodCommandPhase = "Failed"
+)
+
+// PodCommandStatus defines the observed state of PodCommand
+type PodCommandStatus struct {
+	Phase PodCommandPhase `json:"phase,omitempty"`
+
+	// Why the command failed or was not run
+	Message string `json:"message,omitempty"`
+
+	// Node the command ran on
+	Node string `json:"node,omitempty"`
+
+	// Command line as run, with the namespaces entered
+	Command []string `json:"command,omitempty"`
+
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	ExitCode *int32 `json:"exitCode,omitempty"`
+
+	// Last bytes of the command output
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.spec.template`
+// +kubebuilder:printcolumn:name="Pod",type=string,JSONPath=`.spec.podName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Exit Code",type=integer,JSONPath=`.status.exitCode`
+
+// PodCommand is the Schema for the podcommands API
+type PodCommand struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodCommandSpec   `json:"spec"`
+	Status PodCommandStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodCommandList contains a list of PodCommand
+type PodCommandList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodCommand `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodCommand{}, &PodCommandList{})
+}
@@ -0,0 +1,81 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var podcommandlog = logf.Log.WithName("podcommand-resource")
+
+// SetupWebhookWithManager registers the pod command webhook with the manager.
+// Templates are read with the cluster reader of the podconfig webhooks.
+func (r *PodCommand) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if webhookOptions.ClusterReader == nil {
+		webhookOptions.ClusterReader = mgr.GetClient()
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-podconfig-opdev-io-v1alpha1-podcommand,mutating=false,failurePolicy=fail,groups=podconfig.opdev.io,resources=podcommands,versions=v1alpha1,name=vpodcommand.kb.io
+
+var _ webhook.Validator = &PodCommand{}
+
+// ValidateCreate checks the pod command against its template
+func (r *PodCommand) ValidateCreate() error {
+	podcommandlog.Info("validate create", "name", r.Name)
+
+	_, allErrs, err := TemplateViolations(context.TODO(), webhookOptions.ClusterReader, r)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "PodCommand"},
+		r.Name, allErrs)
+}
+
+// ValidateUpdate keeps the spec of a pod command as it was approved
+func (r *PodCommand) ValidateUpdate(old runtime.Object) error {
+	podcommandlog.Info("validate update", "name", r.Name)
+
+	if equality.Semantic.DeepEqual(old.(*PodCommand).Spec, r.Spec) {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "PodCommand"},
+		r.Name, field.ErrorList{field.Forbidden(field.NewPath("spec"), "pod command spec can't be changed")})
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *PodCommand) ValidateDelete() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CommandNamespace type for the pod namespaces a command can be run in
+// +kubebuilder:validation:Enum=net;uts;ipc;pid
+type CommandNamespace string
+
+// Command namespace const values, named after their /proc/<pid>/ns entries.
+// The mount namespace is never entered: the executable would be looked up in
+// the container file system, which the tenant controls, and run with the
+// privileges of the operator.
+const (
+	CommandNamespaceNet CommandNamespace = "net"
+	CommandNamespaceUTS CommandNamespace = "uts"
+	CommandNamespaceIPC CommandNamespace = "ipc"
+	CommandNamespacePID CommandNamespace = "pid"
+)
+
+// CommandArguments type for the arguments a pod command may append to a template
+type CommandArguments struct {
+	// Maximum number of arguments
+	MaxCount int32 `json:"maxCount"`
+
+	// Regular expression every argument must match as a whole
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// PodCommandTemplateSpec defines a command administrators approved for pods
+type PodCommandTemplateSpec struct {
+	// Namespaces allowed to run the command, all namespaces when not set
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Executable and leading arguments, looked up in the operator image
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+
+	// Pod namespaces entered to run the command: net, uts, ipc or pid.
+	// Only the net namespace when empty.
+	Namespaces []CommandNamespace `json:"namespaces,omitempty"`
+
+	// Arguments pod commands may append, none when not set
+	Arguments *CommandArguments `json:"arguments,omitempty"`
+
+	// Longest run allowed, defaults to 30
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// PodCommandTemplate is the Schema for the podcommandtemplates API
+type PodCommandTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PodCommandTemplateSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodCommandTemplateList contains a list of PodCommandTemplate
+type PodCommandTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodCommandTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodCommandTemplate{}, &PodCommandTemplateList{})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -29,13 +30,62 @@ type VlanSpec struct {
 	BridgeName          string `json:"bridgeName,omitempty"`
 }
 
+// PortVlan type for the bridge port of a new Pod interface
+type PortVlan struct {
+	// Untagged VLAN used as the port PVID (access port)
+	Access int16 `json:"access,omitempty"`
+
+	// Tagged VLANs allowed on the port (trunk port)
+	Trunk []int16 `json:"trunk,omitempty"`
+}
+
+// GatewayMode type for the L3 address of the master bridge
+type GatewayMode string
+
+// Gateway mode const values
+const (
+	// No address on the bridge, the segment is L2 only
+	GatewayNone GatewayMode = "none"
+	// Address given in the gateway spec
+	GatewayStatic GatewayMode = "static"
+	// Address allocated from the attachment CIDR
+	GatewayAuto GatewayMode = "auto"
+)
+
+// GatewaySpec type for the host bridge L3 gateway
+type GatewaySpec struct {
+	// Mode is none, static or auto
+	Mode GatewayMode `json:"mode"`
+
+	// Gateway IP address inside the attachment CIDR, used with the static mode
+	Address string `json:"address,omitempty"`
+
+	// Destinations routed through the gateway inside the Pod, the Pod
+	// default route and routes it already has are left alone
+	Routes []string `json:"routes,omitempty"`
+}
+
 // Link type for new Pod interfaces
 type Link struct {
 	Name     string `json:"name,omitempty"`
-	LinkType string `json:"linkType,omitemtpy"` // temporarily used for veth pair
-	Parent   string `json:"parent,omitemtpy"`   // name for the parent interface
-	Master   string `json:"master,omitempty"`   // name for the master bridge
-	CIDR     string `json:"cidr,omitempty"`
+	LinkType string `json:"linkType,omitempty"` // temporarily used for veth pair, defaults to veth
+	Parent   string `json:"parent,omitempty"`   // name for the parent interface
+	Master   string `json:"master,omitempty"`   // name for the master bridge, derived from the podconfig when empty
+	CIDR     string `json:"cidr,omitempty"`     // picked from the cidr pool when empty
+
+	// VLAN membership of the host side port on the master bridge.
+	// When set the master bridge is switched to vlan_filtering mode.
+	Vlan *PortVlan `json:"vlan,omitempty"`
+
+	// Isolated ports can only talk to non isolated ports on the master bridge
+	Isolated bool `json:"isolated,omitempty"`
+
+	// Hairpin lets traffic be sent back out the port it was received on
+	Hairpin bool `json:"hairpin,omitempty"`
+
+	// L3 gateway on the master bridge. When not set the bridge takes the
+	// first free address of the CIDR on creation and no route is added.
+	Gateway *GatewaySpec `json:"gateway,omitempty"`
 
 	// For use with the netlink package  may access all types on the ip stack
 	// Index        int                     `json:"index,omitempty"`
@@ -62,12 +112,94 @@ type Link struct {
 	// Slave        netlink.LinkSlave       `json:"slave,omitempty"`
 }
 
+// BridgeSpec type for bridge level settings of attachment masters
+type BridgeSpec struct {
+	// Name of the master bridge as referenced by the network attachments
+	Name string `json:"name"`
+
+	// Enable the spanning tree protocol
+	STP *bool `json:"stp,omitempty"`
+
+	// STP forward delay in seconds
+	ForwardDelay *uint32 `json:"forwardDelay,omitempty"`
+
+	// MAC address ageing time in seconds
+	AgeingTime *uint32 `json:"ageingTime,omitempty"`
+
+	// Enable IGMP/MLD snooping
+	MulticastSnooping *bool `json:"multicastSnooping,omitempty"`
+
+	// Enable the bridge multicast querier
+	MulticastQuerier *bool `json:"multicastQuerier,omitempty"`
+}
+
+// SysctlSpec sets a sysctl in the network namespace of the pod
+type SysctlSpec struct {
+	// Name such as net.ipv4.conf.all.forwarding, only net sysctls are
+	// namespaced
+	Name string `json:"name"`
+
+	Value string `json:"value"`
+}
+
 // SampleResource for testing with pods
 type SampleResource struct {
 	Create bool   `json:"create,omitempty"`
 	Name   string `json:"name,omitempty"`
 }
 
+// TargetSpec selects a container of the pod, and optionally one of its
+// processes, whose namespaces a configuration applies to
+type TargetSpec struct {
+	// Name of the container in the pod spec
+	Container string `json:"container"`
+
+	// Substring of the command line of a process of the container. The
+	// container main process is the target when empty.
+	Command string `json:"command,omitempty"`
+}
+
+// MountSpec bind mounts a host file or directory into the mount namespace
+// of a target container
+type MountSpec struct {
+	Target TargetSpec `json:"target"`
+
+	// Absolute path of the file or directory on the host
+	HostPath string `json:"hostPath"`
+
+	// Absolute path inside the container, created when missing
+	ContainerPath string `json:"containerPath"`
+
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// CapabilitySpec grants Linux capabilities to the processes started from a
+// binary of a container, through the file capabilities of the binary. Non
+// root processes get them too, as long as they are in the bounding set of
+// the container.
+type CapabilitySpec struct {
+	// Name of the container in the pod spec
+	Container string `json:"container"`
+
+	// Absolute path of the executable in the container
+	Path string `json:"path"`
+
+	// Capability names such as NET_RAW or IPC_LOCK
+	// +kubebuilder:validation:MinItems=1
+	Add []string `json:"add"`
+}
+
+// TeardownSpec type for the removal of the pod configuration
+type TeardownSpec struct {
+	// Keep selected pods from being removed, with a finalizer, until the
+	// host side of their attachments has been torn down
+	PodFinalizer bool `json:"podFinalizer,omitempty"`
+
+	// Seconds after the pod deletion the finalizer is removed even when
+	// the teardown didn't complete, defaults to 60
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
 // PodConfigSpec defines the desired state of PodConfig
 type PodConfigSpec struct {
 	// Flag to enable sample deployment
@@ -76,8 +208,24 @@ type PodConfigSpec struct {
 	// List of new interfaces to configure on Pod
 	NetworkAttachments []Link `json:"networkAttachments,omitempty"`
 
+	// Settings for the master bridges used by the network attachments
+	Bridges []BridgeSpec `json:"bridges,omitempty"`
+
 	// VLANs to be added to subinterfaces
 	Vlans []VlanSpec `json:"vlans,omitempty"`
+
+	// Teardown guarantees for the selected pods
+	Teardown *TeardownSpec `json:"teardown,omitempty"`
+
+	// Host files or directories bind mounted into containers of the pod
+	Mounts []MountSpec `json:"mounts,omitempty"`
+
+	// File capabilities set on binaries of containers of the pod
+	Capabilities []CapabilitySpec `json:"capabilities,omitempty"`
+
+	// Sysctls set in the network namespace of the pod, after the network
+	// attachments are configured
+	Sysctls []SysctlSpec `json:"sysctls,omitempty"`
 }
 
 // PodConfigPhase type for status
@@ -90,17 +238,84 @@ const (
 	PodConfigConfigured  PodConfigPhase = "configured"
 )
 
+// InterfaceStatus of a network attachment configured on a pod
+type InterfaceStatus struct {
+	Attachment string `json:"attachment"`
+	Name       string `json:"name"`              // pod side veth
+	Address    string `json:"address,omitempty"` // pod side address with prefix length
+	HostName   string `json:"hostName"`          // host side veth
+	Bridge     string `json:"bridge"`
+}
+
+// FileCapabilityStatus of a container binary whose file capabilities were set
+type FileCapabilityStatus struct {
+	Container string   `json:"container"`
+	Path      string   `json:"path"`
+	Add       []string `json:"add"`
+
+	// Hex encoded security.capability attribute of the binary before it
+	// was set, empty when it had none. Restored on revert.
+	Previous string `json:"previous,omitempty"`
+}
+
 // PodConfiguration for status
 type PodConfiguration struct {
 	PodName    string   `json:"podName,omitempty"`
 	ConfigList []string `json:"configList,omitemtpy"`
+
+	// Namespace of the pod, always the one of the podconfig
+	Namespace string `json:"namespace,omitempty"`
+
+	// Node the pod was configured on
+	Node string `json:"node,omitempty"`
+
+	// Recorded state of the pod interfaces, compared with the actual
+	// state of the links on every resync
+	Interfaces []InterfaceStatus `json:"interfaces,omitempty"`
+
+	// Bind mounts in place as <container>:<path>
+	Mounts []string `json:"mounts,omitempty"`
+
+	// File capabilities in place, reverted once removed from the spec
+	FileCapabilities []FileCapabilityStatus `json:"fileCapabilities,omitempty"`
+
+	// Links of the pod repaired on the last resync, empty when in sync
+	Drift []string `json:"drift,omitempty"`
+}
+
+// PodConfigConditionType type for status conditions
+type PodConfigConditionType string
+
+// Pod config condition types
+const (
+	// Admitted is false while the podconfig violates a podconfig policy
+	PodConfigAdmitted PodConfigConditionType = "Admitted"
+	// WithinQuota is false while the namespace uses more than its podconfig quotas allow
+	PodConfigWithinQuota PodConfigConditionType = "WithinQuota"
+	// Authorized is false while the operator lacks a permission it needs
+	PodConfigAuthorized PodConfigConditionType = "Authorized"
+	// Drifted is true when the links of any pod differed from the recorded state on its last resync
+	PodConfigDrifted PodConfigConditionType = "Drifted"
+)
+
+// PodConfigCondition for status
+type PodConfigCondition struct {
+	Type               PodConfigConditionType `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
 }
 
 // PodConfigStatus defines the observed state of PodConfig
 type PodConfigStatus struct {
-	// Phase is unset, configuring or configured
+	// Phase is unset, configuring or configured once every running pod
+	// of every node is
 	Phase             PodConfigPhase     `json:"phase,omitempty"`
 	PodConfigurations []PodConfiguration `json:"podConfigurations,omitemtpy"`
+
+	// Latest observations of the podconfig state
+	Conditions []PodConfigCondition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,696 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// Linux interface names are limited to IFNAMSIZ - 1 characters
+	MaxInterfaceNameLength = 15
+
+	// Attachment names get a 7 character suffix identifying the pod
+	// appended and the host side also gets an "h" prefix
+	MaxAttachmentNameLength = MaxInterfaceNameLength - 1 - 7
+
+	// VLAN IDs 0 and 4095 are reserved
+	MinVlanID = 1
+	MaxVlanID = 4094
+)
+
+// LinkTypeVeth is the only link type implemented so far
+const LinkTypeVeth = "veth"
+
+// DefaultsAnnotation lists the spec fields filled in by the defaulting webhook
+const DefaultsAnnotation = "podconfig.opdev.io/defaults"
+
+// DefaultSubnetPrefix is the size of the CIDRs picked from the pool
+const DefaultSubnetPrefix = 24
+
+// DefaultTeardownTimeoutSeconds bounds how long a pod finalizer holds a deleted pod
+const DefaultTeardownTimeoutSeconds = 60
+
+// log is for logging in this package.
+var podconfiglog = logf.Log.WithName("podconfig-resource")
+
+// webhookClient is used to look at the other podconfigs of a namespace
+var webhookClient client.Client
+
+// WebhookOptions for the podconfig admission webhooks
+// +kubebuilder:object:generate=false
+type WebhookOptions struct {
+	// Bridges that podconfigs are allowed to use, empty allows any bridge
+	AllowedBridges []string
+
+	// Host paths, and the paths below them, podconfigs may bind mount into
+	// containers. Empty allows no mounts at all.
+	AllowedHostPaths []string
+
+	// Capabilities podconfigs may set on container binaries. Empty allows
+	// no capabilities at all.
+	AllowedCapabilities []string
+
+	// IPv4 network attachment CIDRs are picked from when not given
+	CIDRPool *net.IPNet
+
+	// Prefix length of the CIDRs picked from the pool
+	SubnetPrefix int
+
+	// Reads cluster scoped objects, defaults to the manager client
+	ClusterReader client.Reader
+}
+
+var webhookOptions WebhookOptions
+
+// SetupWebhookWithManager registers the podconfig webhooks with the manager
+func (r *PodConfig) SetupWebhookWithManager(mgr ctrl.Manager, options WebhookOptions) error {
+	webhookClient = mgr.GetClient()
+	webhookOptions = options
+	if webhookOptions.ClusterReader == nil {
+		webhookOptions.ClusterReader = webhookClient
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-podconfig-opdev-io-v1alpha1-podconfig,mutating=true,failurePolicy=fail,groups=podconfig.opdev.io,resources=podconfigs,verbs=create;update,versions=v1alpha1,name=mpodconfig.kb.io
+
+var _ webhook.Defaulter = &PodConfig{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *PodConfig) Default() {
+	podconfiglog.Info("default", "name", r.Name)
+
+	defaults := []string{}
+	naPath := field.NewPath("spec", "networkAttachments")
+
+	var freeCIDRs []string
+	for i := range r.Spec.NetworkAttachments {
+		na := &r.Spec.NetworkAttachments[i]
+
+		if na.LinkType == "" {
+			na.LinkType = LinkTypeVeth
+			defaults = append(defaults, fmt.Sprintf("%s=%s", naPath.Index(i).Child("linkType"), na.LinkType))
+		}
+		if na.Master == "" {
+			na.Master = r.defaultBridgeName()
+			defaults = append(defaults, fmt.Sprintf("%s=%s", naPath.Index(i).Child("master"), na.Master))
+		}
+		if na.CIDR == "" {
+			if freeCIDRs == nil {
+				var err error
+				freeCIDRs, err = r.freeCIDRs()
+				if err != nil {
+					// Left empty, the validating webhook rejects the object
+					podconfiglog.Error(err, "unable to pick a cidr from the pool", "name", r.Name)
+					continue
+				}
+			}
+			if len(freeCIDRs) == 0 {
+				continue
+			}
+			na.CIDR, freeCIDRs = freeCIDRs[0], freeCIDRs[1:]
+			defaults = append(defaults, fmt.Sprintf("%s=%s", naPath.Index(i).Child("cidr"), na.CIDR))
+		}
+	}
+
+	if len(defaults) == 0 {
+		return
+	}
+	if r.Annotations == nil {
+		r.Annotations = map[string]string{}
+	}
+	if previous := r.Annotations[DefaultsAnnotation]; previous != "" {
+		defaults = append([]string{previous}, defaults...)
+	}
+	r.Annotations[DefaultsAnnotation] = strings.Join(defaults, ",")
+}
+
+// Bridge names are derived from a hash of the podconfig namespace and name
+// so they stay within IFNAMSIZ and are the same on every node
+func (r *PodConfig) defaultBridgeName() string {
+	hash := fnv.New32a()
+	hash.Write([]byte(r.Namespace + "/" + r.Name))
+	return fmt.Sprintf("pcbr%08x", hash.Sum32())
+}
+
+// Lists the pool subnets not used by any podconfig of the cluster. Two
+// podconfigs admitted at the same time may still be given the same subnet.
+func (r *PodConfig) freeCIDRs() ([]string, error) {
+
+	free := []string{}
+
+	pool := webhookOptions.CIDRPool
+	if pool == nil || webhookClient == nil {
+		return free, nil
+	}
+
+	prefix := webhookOptions.SubnetPrefix
+	if prefix == 0 {
+		prefix = DefaultSubnetPrefix
+	}
+	poolPrefix, bits := pool.Mask.Size()
+	if bits != 32 || prefix < poolPrefix || prefix > 30 {
+		return free, fmt.Errorf("subnet prefix /%d does not fit in pool %v", prefix, pool)
+	}
+
+	podConfigList := &PodConfigList{}
+	err := webhookClient.List(context.TODO(), podConfigList)
+	if err != nil {
+		return free, fmt.Errorf("failed to list podconfigs: %v", err)
+	}
+
+	used := []*net.IPNet{}
+	for _, pc := range append(podConfigList.Items, *r) {
+		for _, na := range pc.Spec.NetworkAttachments {
+			if _, ipNet, err := net.ParseCIDR(na.CIDR); err == nil {
+				used = append(used, ipNet)
+			}
+		}
+	}
+
+	base := binary.BigEndian.Uint32(pool.IP.To4())
+	size := uint32(1) << uint(32-prefix)
+	count := uint32(1) << uint(prefix-poolPrefix)
+	for n := uint32(0); n < count; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+n*size)
+		subnet := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, 32)}
+
+		overlaps := false
+		for _, ipNet := range used {
+			if ipNet.Contains(subnet.IP) || subnet.Contains(ipNet.IP) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			free = append(free, subnet.String())
+		}
+	}
+	return free, nil
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-podconfig-opdev-io-v1alpha1-podconfig,mutating=false,failurePolicy=fail,groups=podconfig.opdev.io,resources=podconfigs,versions=v1alpha1,name=vpodconfig.kb.io
+
+var _ webhook.Validator = &PodConfig{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *PodConfig) ValidateCreate() error {
+	podconfiglog.Info("validate create", "name", r.Name)
+
+	return r.validatePodConfig(nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+// Podconfigs admitted before a policy or quota changed are held back by the
+// reconciler, their finalizer and status updates are let through.
+func (r *PodConfig) ValidateUpdate(old runtime.Object) error {
+	podconfiglog.Info("validate update", "name", r.Name)
+
+	if !r.ObjectMeta.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	oldPodConfig := old.(*PodConfig)
+	if reflect.DeepEqual(oldPodConfig.Spec, r.Spec) {
+		return r.invalid(r.validateSpec())
+	}
+	return r.validatePodConfig(oldPodConfig)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *PodConfig) ValidateDelete() error {
+	return nil
+}
+
+func (r *PodConfig) validatePodConfig(old *PodConfig) error {
+
+	allErrs := r.validateSpec()
+
+	overlapErrs, err := r.validateCIDROverlap()
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	allErrs = append(allErrs, overlapErrs...)
+
+	bridgeErrs, err := r.validateBridgeOptions()
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	allErrs = append(allErrs, bridgeErrs...)
+
+	if webhookClient != nil {
+		policyErrs, err := PolicyViolations(context.TODO(), webhookOptions.ClusterReader, r)
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		allErrs = append(allErrs, policyErrs...)
+
+		quotaErrs, err := r.validateQuota(old)
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		allErrs = append(allErrs, quotaErrs...)
+	}
+
+	return r.invalid(allErrs)
+}
+
+func (r *PodConfig) invalid(allErrs field.ErrorList) error {
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "PodConfig"},
+		r.Name, allErrs)
+}
+
+// Checks that don't need any other object than the podconfig itself
+func (r *PodConfig) validateSpec() field.ErrorList {
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	names := map[string]bool{}
+	// Gateway routes of all attachments, added to the same pod
+	routed := []*net.IPNet{}
+	for i, na := range r.Spec.NetworkAttachments {
+		naPath := specPath.Child("networkAttachments").Index(i)
+
+		switch {
+		case na.Name == "":
+			allErrs = append(allErrs, field.Required(naPath.Child("name"), "attachment name is required"))
+		case len(na.Name) > MaxAttachmentNameLength:
+			allErrs = append(allErrs, field.TooLong(naPath.Child("name"), na.Name, MaxAttachmentNameLength))
+		case names[na.Name]:
+			allErrs = append(allErrs, field.Duplicate(naPath.Child("name"), na.Name))
+		}
+		names[na.Name] = true
+
+		if na.LinkType != LinkTypeVeth {
+			allErrs = append(allErrs, field.NotSupported(naPath.Child("linkType"), na.LinkType, []string{LinkTypeVeth}))
+		}
+
+		allErrs = append(allErrs, validateBridgeName(naPath.Child("master"), na.Master)...)
+
+		_, ipNet, err := net.ParseCIDR(na.CIDR)
+		if na.CIDR == "" {
+			allErrs = append(allErrs, field.Required(naPath.Child("cidr"), "no cidr given and no free subnet left in the cidr pool"))
+		} else if err != nil || ipNet.IP.To4() == nil {
+			allErrs = append(allErrs, field.Invalid(naPath.Child("cidr"), na.CIDR, "must be an IPv4 CIDR"))
+			ipNet = nil
+		}
+
+		if na.Vlan != nil {
+			allErrs = append(allErrs, validateVlanID(naPath.Child("vlan", "access"), na.Vlan.Access, true)...)
+			for j, vid := range na.Vlan.Trunk {
+				allErrs = append(allErrs, validateVlanID(naPath.Child("vlan", "trunk").Index(j), vid, false)...)
+			}
+		}
+
+		if na.Gateway != nil {
+			var errs field.ErrorList
+			errs, routed = validateGateway(naPath.Child("gateway"), na.Gateway, ipNet, routed)
+			allErrs = append(allErrs, errs...)
+		}
+	}
+
+	for i, br := range r.Spec.Bridges {
+		allErrs = append(allErrs, validateBridgeName(specPath.Child("bridges").Index(i).Child("name"), br.Name)...)
+	}
+
+	for i, vlan := range r.Spec.Vlans {
+		vlanPath := specPath.Child("vlans").Index(i)
+		allErrs = append(allErrs, validateVlanID(vlanPath.Child("vlanID"), vlan.VlanID, false)...)
+		if vlan.BridgeName != "" {
+			allErrs = append(allErrs, validateBridgeName(vlanPath.Child("bridgeName"), vlan.BridgeName)...)
+		}
+	}
+
+	for i, mount := range r.Spec.Mounts {
+		allErrs = append(allErrs, validateMount(specPath.Child("mounts").Index(i), mount)...)
+	}
+
+	for i, capability := range r.Spec.Capabilities {
+		allErrs = append(allErrs, validateCapability(specPath.Child("capabilities").Index(i), capability)...)
+	}
+
+	for i, sysctl := range r.Spec.Sysctls {
+		allErrs = append(allErrs, validateSysctl(specPath.Child("sysctls").Index(i), sysctl)...)
+	}
+
+	if r.Spec.Teardown != nil && r.Spec.Teardown.TimeoutSeconds != nil && *r.Spec.Teardown.TimeoutSeconds < 1 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("teardown", "timeoutSeconds"), *r.Spec.Teardown.TimeoutSeconds, "must be at least 1"))
+	}
+
+	return allErrs
+}
+
+func validateBridgeName(path *field.Path, name string) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if name == "" {
+		return append(allErrs, field.Required(path, "bridge name is required"))
+	}
+	if len(name) > MaxInterfaceNameLength {
+		allErrs = append(allErrs, field.TooLong(path, name, MaxInterfaceNameLength))
+	}
+	if len(webhookOptions.AllowedBridges) > 0 && !containsString(webhookOptions.AllowedBridges, name) {
+		allErrs = append(allErrs, field.NotSupported(path, name, webhookOptions.AllowedBridges))
+	}
+	return allErrs
+}
+
+// HostPathAllowed tells whether a host path is one of the allowed paths or
+// below one of them
+func HostPathAllowed(hostPath string, allowedPaths []string) bool {
+
+	clean := filepath.Clean(hostPath)
+	for _, allowed := range allowedPaths {
+		allowed = filepath.Clean(allowed)
+		if clean == allowed || strings.HasPrefix(clean, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateMount(path *field.Path, mount MountSpec) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if mount.Target.Container == "" {
+		allErrs = append(allErrs, field.Required(path.Child("target", "container"), "container name is required"))
+	}
+
+	if !filepath.IsAbs(mount.ContainerPath) {
+		allErrs = append(allErrs, field.Invalid(path.Child("containerPath"), mount.ContainerPath, "must be an absolute path"))
+	}
+
+	hostPath := path.Child("hostPath")
+	if !filepath.IsAbs(mount.HostPath) {
+		return append(allErrs, field.Invalid(hostPath, mount.HostPath, "must be an absolute path"))
+	}
+	if HostPathAllowed(mount.HostPath, webhookOptions.AllowedHostPaths) {
+		return allErrs
+	}
+	if len(webhookOptions.AllowedHostPaths) == 0 {
+		return append(allErrs, field.Forbidden(hostPath, "no host paths are allowed"))
+	}
+	return append(allErrs, field.Forbidden(hostPath, fmt.Sprintf("must be one of or below %v", webhookOptions.AllowedHostPaths)))
+}
+
+func validateCapability(path *field.Path, capability CapabilitySpec) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if capability.Container == "" {
+		allErrs = append(allErrs, field.Required(path.Child("container"), "container name is required"))
+	}
+	if !filepath.IsAbs(capability.Path) || filepath.Clean(capability.Path) != capability.Path {
+		allErrs = append(allErrs, field.Invalid(path.Child("path"), capability.Path, "must be a clean absolute path"))
+	}
+	if len(capability.Add) == 0 {
+		allErrs = append(allErrs, field.Required(path.Child("add"), "at least one capability is required"))
+	}
+
+	allowed := []string{}
+	for _, name := range webhookOptions.AllowedCapabilities {
+		allowed = append(allowed, CapabilityName(name))
+	}
+	for i, name := range capability.Add {
+		if _, ok := CapabilityNumber(name); !ok {
+			allErrs = append(allErrs, field.Invalid(path.Child("add").Index(i), name, "unknown capability"))
+			continue
+		}
+		if len(allowed) == 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("add").Index(i), "no capabilities are allowed"))
+			continue
+		}
+		if !containsString(allowed, CapabilityName(name)) {
+			allErrs = append(allErrs, field.NotSupported(path.Child("add").Index(i), name, allowed))
+		}
+	}
+	return allErrs
+}
+
+var sysctlName = regexp.MustCompile(`^net(\.[a-zA-Z0-9_-]+)+$`)
+
+// SysctlNamespaced tells whether a sysctl belongs to the network namespace
+// of the pod. Names with slashes, as used for interface names with dots,
+// are refused so that they can't leave /proc/sys/net.
+func SysctlNamespaced(name string) bool {
+	return sysctlName.MatchString(name)
+}
+
+func validateSysctl(path *field.Path, sysctl SysctlSpec) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if !SysctlNamespaced(sysctl.Name) {
+		allErrs = append(allErrs, field.Invalid(path.Child("name"), sysctl.Name, "must be a net sysctl such as net.ipv4.conf.all.forwarding"))
+	}
+	if sysctl.Value == "" || strings.ContainsAny(sysctl.Value, "\n\x00") {
+		allErrs = append(allErrs, field.Invalid(path.Child("value"), sysctl.Value, "must be a single line value"))
+	}
+	return allErrs
+}
+
+// VLAN 0 means no VLAN for optional fields
+func validateVlanID(path *field.Path, vid int16, optional bool) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if optional && vid == 0 {
+		return allErrs
+	}
+	if vid < MinVlanID || vid > MaxVlanID {
+		allErrs = append(allErrs, field.Invalid(path, vid, fmt.Sprintf("must be between %d and %d", MinVlanID, MaxVlanID)))
+	}
+	return allErrs
+}
+
+// Routes are added next to the ones the pod already has, the default route
+// and destinations overlapping the attachment CIDR or other gateway routes
+// are refused. Returns routed with the routes of the gateway appended.
+func validateGateway(path *field.Path, gateway *GatewaySpec, ipNet *net.IPNet, routed []*net.IPNet) (field.ErrorList, []*net.IPNet) {
+
+	var allErrs field.ErrorList
+
+	switch gateway.Mode {
+	case GatewayNone:
+		if len(gateway.Routes) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("routes"), "routes need a gateway address"))
+		}
+	case GatewayStatic:
+		ip := net.ParseIP(gateway.Address)
+		if ip == nil || (ipNet != nil && !ipNet.Contains(ip)) {
+			allErrs = append(allErrs, field.Invalid(path.Child("address"), gateway.Address, "must be an address of the attachment cidr"))
+		}
+	case GatewayAuto:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("mode"), gateway.Mode,
+			[]string{string(GatewayNone), string(GatewayStatic), string(GatewayAuto)}))
+	}
+
+	for i, route := range gateway.Routes {
+		routePath := path.Child("routes").Index(i)
+		_, dst, err := net.ParseCIDR(route)
+		if err != nil || dst.IP.To4() == nil {
+			allErrs = append(allErrs, field.Invalid(routePath, route, "must be an IPv4 CIDR"))
+			continue
+		}
+		if ones, _ := dst.Mask.Size(); ones == 0 {
+			allErrs = append(allErrs, field.Forbidden(routePath, "the pod default route can't be replaced"))
+			continue
+		}
+		if ipNet != nil && cidrsOverlap(dst, ipNet) {
+			allErrs = append(allErrs, field.Invalid(routePath, route, "overlaps with the attachment cidr"))
+			continue
+		}
+		for _, other := range routed {
+			if cidrsOverlap(dst, other) {
+				allErrs = append(allErrs, field.Invalid(routePath, route, fmt.Sprintf("overlaps with gateway route %s", other)))
+				break
+			}
+		}
+		routed = append(routed, dst)
+	}
+	return allErrs, routed
+}
+
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Rejects CIDRs overlapping with the ones used by other podconfigs of the namespace
+func (r *PodConfig) validateCIDROverlap() (field.ErrorList, error) {
+
+	var allErrs field.ErrorList
+
+	if webhookClient == nil {
+		return allErrs, nil
+	}
+
+	podConfigList := &PodConfigList{}
+	err := webhookClient.List(context.TODO(), podConfigList, client.InNamespace(r.Namespace))
+	if err != nil {
+		return allErrs, fmt.Errorf("failed to list podconfigs: %v", err)
+	}
+
+	for i, na := range r.Spec.NetworkAttachments {
+		_, ipNet, err := net.ParseCIDR(na.CIDR)
+		if err != nil {
+			continue
+		}
+		for _, other := range podConfigList.Items {
+			if other.Name == r.Name {
+				continue
+			}
+			for _, otherNa := range other.Spec.NetworkAttachments {
+				_, otherNet, err := net.ParseCIDR(otherNa.CIDR)
+				if err != nil {
+					continue
+				}
+				if cidrsOverlap(ipNet, otherNet) {
+					allErrs = append(allErrs, field.Invalid(
+						field.NewPath("spec", "networkAttachments").Index(i).Child("cidr"), na.CIDR,
+						fmt.Sprintf("overlaps with %s used by podconfig %s", otherNa.CIDR, other.Name)))
+				}
+			}
+		}
+	}
+	return allErrs, nil
+}
+
+// Rejects bridge options conflicting with the ones other podconfigs of the
+// namespace set on the same bridge, the last one applied would win
+func (r *PodConfig) validateBridgeOptions() (field.ErrorList, error) {
+
+	var allErrs field.ErrorList
+
+	if webhookClient == nil || len(r.Spec.Bridges) == 0 {
+		return allErrs, nil
+	}
+
+	podConfigList := &PodConfigList{}
+	err := webhookClient.List(context.TODO(), podConfigList, client.InNamespace(r.Namespace))
+	if err != nil {
+		return allErrs, fmt.Errorf("failed to list podconfigs: %v", err)
+	}
+
+	for i, bridge := range r.Spec.Bridges {
+		options := bridgeOptions(bridge)
+		for _, other := range podConfigList.Items {
+			if other.Name == r.Name {
+				continue
+			}
+			for _, otherBridge := range other.Spec.Bridges {
+				if otherBridge.Name != bridge.Name {
+					continue
+				}
+				for option, otherValue := range bridgeOptions(otherBridge) {
+					if value, ok := options[option]; ok && value != otherValue {
+						allErrs = append(allErrs, field.Invalid(
+							field.NewPath("spec", "bridges").Index(i).Child(option), value,
+							fmt.Sprintf("conflicts with %v set by podconfig %s", otherValue, other.Name)))
+					}
+				}
+			}
+		}
+	}
+	return allErrs, nil
+}
+
+// Options set in a bridge spec, by field name
+func bridgeOptions(bridge BridgeSpec) map[string]interface{} {
+
+	options := map[string]interface{}{}
+	if bridge.STP != nil {
+		options["stp"] = *bridge.STP
+	}
+	if bridge.ForwardDelay != nil {
+		options["forwardDelay"] = *bridge.ForwardDelay
+	}
+	if bridge.AgeingTime != nil {
+		options["ageingTime"] = *bridge.AgeingTime
+	}
+	if bridge.MulticastSnooping != nil {
+		options["multicastSnooping"] = *bridge.MulticastSnooping
+	}
+	if bridge.MulticastQuerier != nil {
+		options["multicastQuerier"] = *bridge.MulticastQuerier
+	}
+	return options
+}
+
+// Rejects podconfigs making the namespace usage grow over its quotas,
+// compared with the podconfig being replaced. Pods scaled up past a quota
+// are held back by the reconciler, changes that don't add anything are let
+// through even over quota.
+func (r *PodConfig) validateQuota(old *PodConfig) (field.ErrorList, error) {
+
+	current, err := NamespaceUsage(context.TODO(), webhookClient, r.Namespace, old)
+	if err != nil {
+		return nil, err
+	}
+	requested, err := NamespaceUsage(context.TODO(), webhookClient, r.Namespace, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if requested.Attachments <= current.Attachments {
+		requested.Attachments = 0
+	}
+	if requested.Bridges <= current.Bridges {
+		requested.Bridges = 0
+	}
+	if requested.Addresses <= current.Addresses {
+		requested.Addresses = 0
+	}
+	return QuotaViolations(context.TODO(), webhookClient, r.Namespace, requested)
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+package v1alpha1
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func newPodConfig(name string, attachments ...Link) *PodConfig {
+	return &PodConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       PodConfigSpec{NetworkAttachments: attachments},
+	}
+}
+
+func newAttachment(modify func(na *Link)) Link {
+	na := Link{Name: "net1", LinkType: LinkTypeVeth, Master: "br0", CIDR: "192.168.100.0/24"}
+	if modify != nil {
+		modify(&na)
+	}
+	return na
+}
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func errorFields(errs field.ErrorList) []string {
+	fields := []string{}
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
+var _ = Describe("PodConfig webhook", func() {
+
+	AfterEach(func() {
+		webhookClient = nil
+		webhookOptions = WebhookOptions{}
+	})
+
+	DescribeTable("attachment checks",
+		func(na Link, fields ...string) {
+			errs := newPodConfig("pc", na).validateSpec()
+			Expect(errorFields(errs)).To(ConsistOf(fields))
+		},
+		Entry("accepts a valid attachment", newAttachment(nil)),
+		Entry("requires a name", newAttachment(func(na *Link) { na.Name = "" }),
+			"spec.networkAttachments[0].name"),
+		Entry("leaves room for the link suffix in the name", newAttachment(func(na *Link) { na.Name = "attachment" }),
+			"spec.networkAttachments[0].name"),
+		Entry("only supports veth links", newAttachment(func(na *Link) { na.LinkType = "macvlan" }),
+			"spec.networkAttachments[0].linkType"),
+		Entry("requires a master bridge", newAttachment(func(na *Link) { na.Master = "" }),
+			"spec.networkAttachments[0].master"),
+		Entry("requires an IPv4 cidr", newAttachment(func(na *Link) { na.CIDR = "fd00::/64" }),
+			"spec.networkAttachments[0].cidr"),
+		Entry("allows no access vlan", newAttachment(func(na *Link) { na.Vlan = &PortVlan{Trunk: []int16{10}} })),
+		Entry("rejects reserved vlans", newAttachment(func(na *Link) { na.Vlan = &PortVlan{Access: 4095, Trunk: []int16{0}} }),
+			"spec.networkAttachments[0].vlan.access", "spec.networkAttachments[0].vlan.trunk[0]"),
+		Entry("rejects unknown gateway modes", newAttachment(func(na *Link) { na.Gateway = &GatewaySpec{Mode: "dhcp"} }),
+			"spec.networkAttachments[0].gateway.mode"),
+		Entry("requires a static gateway in the cidr", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayStatic, Address: "10.0.0.1"}
+		}), "spec.networkAttachments[0].gateway.address"),
+		Entry("rejects routes without a gateway address", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayNone, Routes: []string{"10.10.0.0/16"}}
+		}), "spec.networkAttachments[0].gateway.routes"),
+		Entry("accepts routes through the gateway", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"10.10.0.0/16", "10.20.0.0/16"}}
+		})),
+		Entry("rejects IPv6 routes", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"fd00::/64"}}
+		}), "spec.networkAttachments[0].gateway.routes[0]"),
+		Entry("never replaces the pod default route", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"0.0.0.0/0"}}
+		}), "spec.networkAttachments[0].gateway.routes[0]"),
+		Entry("rejects routes overlapping the attachment cidr", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"192.168.0.0/16"}}
+		}), "spec.networkAttachments[0].gateway.routes[0]"),
+		Entry("rejects overlapping gateway routes", newAttachment(func(na *Link) {
+			na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"10.0.0.0/8", "10.10.0.0/16"}}
+		}), "spec.networkAttachments[0].gateway.routes[1]"),
+	)
+
+	DescribeTable("sysctl checks",
+		func(sysctl SysctlSpec, fields ...string) {
+			pc := newPodConfig("pc", newAttachment(nil))
+			pc.Spec.Sysctls = []SysctlSpec{sysctl}
+			Expect(errorFields(pc.validateSpec())).To(ConsistOf(fields))
+		},
+		Entry("accepts net sysctls", SysctlSpec{Name: "net.ipv4.conf.net1.forwarding", Value: "1"}),
+		Entry("rejects sysctls outside the network namespace", SysctlSpec{Name: "kernel.pid_max", Value: "4096"},
+			"spec.sysctls[0].name"),
+		Entry("rejects names leaving /proc/sys/net", SysctlSpec{Name: "net/../kernel/pid_max", Value: "4096"},
+			"spec.sysctls[0].name"),
+		Entry("requires a value", SysctlSpec{Name: "net.ipv4.ip_forward"},
+			"spec.sysctls[0].value"),
+		Entry("rejects multi line values", SysctlSpec{Name: "net.ipv4.ip_forward", Value: "1\n0"},
+			"spec.sysctls[0].value"),
+	)
+
+	DescribeTable("mount checks",
+		func(mount MountSpec, fields ...string) {
+			webhookOptions.AllowedHostPaths = []string{"/var/lib/cnf"}
+			pc := newPodConfig("pc", newAttachment(nil))
+			pc.Spec.Mounts = []MountSpec{mount}
+			Expect(errorFields(pc.validateSpec())).To(ConsistOf(fields))
+		},
+		Entry("accepts mounts below the allowed host paths",
+			MountSpec{Target: TargetSpec{Container: "cnf"}, HostPath: "/var/lib/cnf/license", ContainerPath: "/etc/license"}),
+		Entry("requires a target container",
+			MountSpec{HostPath: "/var/lib/cnf", ContainerPath: "/etc/license"},
+			"spec.mounts[0].target.container"),
+		Entry("requires absolute paths",
+			MountSpec{Target: TargetSpec{Container: "cnf"}, HostPath: "var/lib/cnf", ContainerPath: "etc/license"},
+			"spec.mounts[0].containerPath", "spec.mounts[0].hostPath"),
+		Entry("rejects host paths leaving the allowed paths",
+			MountSpec{Target: TargetSpec{Container: "cnf"}, HostPath: "/var/lib/cnf/../../../etc/shadow", ContainerPath: "/etc/license"},
+			"spec.mounts[0].hostPath"),
+	)
+
+	It("allows no mounts without allowed host paths", func() {
+		pc := newPodConfig("pc", newAttachment(nil))
+		pc.Spec.Mounts = []MountSpec{{Target: TargetSpec{Container: "cnf"}, HostPath: "/var/lib/cnf", ContainerPath: "/etc/license"}}
+		errs := pc.validateSpec()
+		Expect(errorFields(errs)).To(ConsistOf("spec.mounts[0].hostPath"))
+		Expect(errs[0].Type).To(Equal(field.ErrorTypeForbidden))
+	})
+
+	DescribeTable("capability checks",
+		func(capability CapabilitySpec, fields ...string) {
+			webhookOptions.AllowedCapabilities = []string{"net_raw", "CAP_IPC_LOCK"}
+			pc := newPodConfig("pc", newAttachment(nil))
+			pc.Spec.Capabilities = []CapabilitySpec{capability}
+			Expect(errorFields(pc.validateSpec())).To(ConsistOf(fields))
+		},
+		Entry("accepts allowed capabilities in any form",
+			CapabilitySpec{Container: "cnf", Path: "/usr/bin/cnf-agent", Add: []string{"NET_RAW", "cap_ipc_lock"}}),
+		Entry("requires a container and a clean absolute path",
+			CapabilitySpec{Path: "/usr/bin/../bin/cnf-agent", Add: []string{"NET_RAW"}},
+			"spec.capabilities[0].container", "spec.capabilities[0].path"),
+		Entry("requires a capability",
+			CapabilitySpec{Container: "cnf", Path: "/usr/bin/cnf-agent"},
+			"spec.capabilities[0].add"),
+		Entry("rejects unknown and not allowed capabilities",
+			CapabilitySpec{Container: "cnf", Path: "/usr/bin/cnf-agent", Add: []string{"NET_RAW", "NOT_A_CAP", "SYS_ADMIN"}},
+			"spec.capabilities[0].add[1]", "spec.capabilities[0].add[2]"),
+	)
+
+	It("allows no capabilities without allowed capabilities", func() {
+		pc := newPodConfig("pc", newAttachment(nil))
+		pc.Spec.Capabilities = []CapabilitySpec{{Container: "cnf", Path: "/usr/bin/cnf-agent", Add: []string{"NET_RAW"}}}
+		errs := pc.validateSpec()
+		Expect(errorFields(errs)).To(ConsistOf("spec.capabilities[0].add[0]"))
+		Expect(errs[0].Type).To(Equal(field.ErrorTypeForbidden))
+	})
+
+	It("numbers capabilities whatever the form of their name", func() {
+		Expect(CapabilityName("cap_net_raw")).To(Equal("NET_RAW"))
+
+		number, ok := CapabilityNumber("CAP_BPF")
+		Expect(ok).To(BeTrue())
+		Expect(number).To(Equal(uint(39)))
+
+		_, ok = CapabilityNumber("NOT_A_CAP")
+		Expect(ok).To(BeFalse())
+	})
+
+	DescribeTable("allowed host paths",
+		func(hostPath string, allowed bool) {
+			Expect(HostPathAllowed(hostPath, []string{"/var/lib/cnf/", "/etc/cnf"})).To(Equal(allowed))
+		},
+		Entry("an allowed path", "/var/lib/cnf", true),
+		Entry("a path below an allowed path", "/etc/cnf/license", true),
+		Entry("a path sharing a prefix with an allowed path", "/etc/cnfs", false),
+		Entry("a path climbing out of an allowed path", "/etc/cnf/../shadow", false),
+	)
+
+	It("rejects duplicate attachment names", func() {
+		errs := newPodConfig("pc", newAttachment(nil), newAttachment(nil)).validateSpec()
+		Expect(errorFields(errs)).To(ConsistOf("spec.networkAttachments[1].name"))
+	})
+
+	It("requires a teardown timeout of at least a second", func() {
+		timeout := int32(0)
+		pc := newPodConfig("pc", newAttachment(nil))
+		pc.Spec.Teardown = &TeardownSpec{PodFinalizer: true, TimeoutSeconds: &timeout}
+		Expect(errorFields(pc.validateSpec())).To(ConsistOf("spec.teardown.timeoutSeconds"))
+
+		timeout = 1
+		Expect(pc.validateSpec()).To(BeEmpty())
+	})
+
+	It("rejects gateway routes overlapping the ones of another attachment", func() {
+		pc := newPodConfig("pc",
+			newAttachment(func(na *Link) {
+				na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"10.10.0.0/16"}}
+			}),
+			newAttachment(func(na *Link) {
+				na.Name = "net2"
+				na.Master = "br1"
+				na.CIDR = "192.168.101.0/24"
+				na.Gateway = &GatewaySpec{Mode: GatewayAuto, Routes: []string{"10.10.1.0/24"}}
+			}))
+
+		errs := pc.validateSpec()
+		Expect(errorFields(errs)).To(ConsistOf("spec.networkAttachments[1].gateway.routes[0]"))
+		Expect(errs[0].Detail).To(Equal("overlaps with gateway route 10.10.0.0/16"))
+	})
+
+	It("only allows the configured bridges", func() {
+		webhookOptions = WebhookOptions{AllowedBridges: []string{"br0"}}
+
+		Expect(newPodConfig("pc", newAttachment(nil)).validateSpec()).To(BeEmpty())
+
+		errs := newPodConfig("pc", newAttachment(func(na *Link) { na.Master = "br1" })).validateSpec()
+		Expect(errorFields(errs)).To(ConsistOf("spec.networkAttachments[0].master"))
+	})
+
+	Context("with other podconfigs in the namespace", func() {
+
+		BeforeEach(func() {
+			stp := true
+			other := newPodConfig("other", newAttachment(func(na *Link) { na.CIDR = "192.168.0.0/16" }))
+			other.Spec.Bridges = []BridgeSpec{{Name: "br0", STP: &stp}}
+
+			elsewhere := newPodConfig("elsewhere", newAttachment(func(na *Link) { na.CIDR = "10.0.0.0/8" }))
+			elsewhere.Namespace = "other"
+
+			useFakeClient(newNamespace("default", nil), newNamespace("other", nil), other, elsewhere)
+		})
+
+		It("rejects overlapping cidrs", func() {
+			err := newPodConfig("pc", newAttachment(nil)).ValidateCreate()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("overlaps with 192.168.0.0/16 used by podconfig other"))
+		})
+
+		It("accepts cidrs used in other namespaces", func() {
+			pc := newPodConfig("pc", newAttachment(func(na *Link) { na.CIDR = "10.1.0.0/24" }))
+			Expect(pc.ValidateCreate()).To(Succeed())
+		})
+
+		It("does not compare a podconfig with itself", func() {
+			pc := newPodConfig("other", newAttachment(func(na *Link) { na.CIDR = "192.168.0.0/24" }))
+			Expect(pc.ValidateUpdate(pc.DeepCopy())).To(Succeed())
+		})
+
+		It("rejects conflicting options on the same bridge", func() {
+			stp := false
+			pc := newPodConfig("pc", newAttachment(func(na *Link) { na.CIDR = "10.1.0.0/24" }))
+			pc.Spec.Bridges = []BridgeSpec{{Name: "br0", STP: &stp}}
+
+			err := pc.ValidateCreate()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.bridges[0].stp"))
+			Expect(err.Error()).To(ContainSubstring("conflicts with true set by podconfig other"))
+		})
+
+		It("accepts the same options or other bridges", func() {
+			stp := true
+			ageing := uint32(30)
+			pc := newPodConfig("pc", newAttachment(func(na *Link) { na.CIDR = "10.1.0.0/24" }))
+			pc.Spec.Bridges = []BridgeSpec{{Name: "br0", STP: &stp, AgeingTime: &ageing}, {Name: "br1", STP: new(bool)}}
+
+			Expect(pc.ValidateCreate()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("PodConfig defaulting", func() {
+
+	AfterEach(func() {
+		webhookClient = nil
+		webhookOptions = WebhookOptions{}
+	})
+
+	It("fills in the link type and a bridge named after the podconfig", func() {
+		pc := newPodConfig("pc", Link{Name: "net1", CIDR: "192.168.100.0/24"})
+		pc.Default()
+
+		na := pc.Spec.NetworkAttachments[0]
+		Expect(na.LinkType).To(Equal(LinkTypeVeth))
+		Expect(na.Master).To(MatchRegexp("^pcbr[0-9a-f]{8}$"))
+		Expect(len(na.Master)).To(BeNumerically("<=", MaxInterfaceNameLength))
+		Expect(pc.Annotations).To(HaveKeyWithValue(DefaultsAnnotation,
+			"spec.networkAttachments[0].linkType=veth,spec.networkAttachments[0].master="+na.Master))
+	})
+
+	It("names the same bridge for the same podconfig on every call", func() {
+		first := newPodConfig("pc", Link{Name: "net1"})
+		second := newPodConfig("pc", Link{Name: "net1"})
+		other := newPodConfig("other", Link{Name: "net1"})
+		first.Default()
+		second.Default()
+		other.Default()
+
+		Expect(first.Spec.NetworkAttachments[0].Master).To(Equal(second.Spec.NetworkAttachments[0].Master))
+		Expect(first.Spec.NetworkAttachments[0].Master).NotTo(Equal(other.Spec.NetworkAttachments[0].Master))
+	})
+
+	It("leaves the parent interface alone", func() {
+		pc := newPodConfig("pc", newAttachment(nil))
+		pc.Default()
+
+		Expect(pc.Spec.NetworkAttachments[0].Parent).To(BeEmpty())
+		Expect(pc.Annotations).NotTo(HaveKey(DefaultsAnnotation))
+	})
+
+	It("keeps the fields that were set", func() {
+		pc := newPodConfig("pc", newAttachment(nil))
+		pc.Default()
+
+		Expect(pc.Spec.NetworkAttachments[0]).To(Equal(newAttachment(nil)))
+	})
+
+	It("picks cidrs from the pool that no podconfig uses", func() {
+		_, pool, _ := net.ParseCIDR("10.100.0.0/16")
+		webhookOptions = WebhookOptions{CIDRPool: pool}
+		other := newPodConfig("other", newAttachment(func(na *Link) { na.CIDR = "10.100.0.0/24" }))
+		useFakeClient(other)
+
+		pc := newPodConfig("pc",
+			newAttachment(func(na *Link) { na.CIDR = "" }),
+			newAttachment(func(na *Link) { na.Name = "net2"; na.CIDR = "10.100.1.0/24" }),
+			newAttachment(func(na *Link) { na.Name = "net3"; na.CIDR = "" }))
+		pc.Default()
+
+		Expect(pc.Spec.NetworkAttachments[0].CIDR).To(Equal("10.100.2.0/24"))
+		Expect(pc.Spec.NetworkAttachments[2].CIDR).To(Equal("10.100.3.0/24"))
+		Expect(pc.Annotations).To(HaveKeyWithValue(DefaultsAnnotation,
+			"spec.networkAttachments[0].cidr=10.100.2.0/24,spec.networkAttachments[2].cidr=10.100.3.0/24"))
+	})
+
+	It("sizes the cidrs with the pool prefix", func() {
+		_, pool, _ := net.ParseCIDR("10.100.0.0/16")
+		webhookOptions = WebhookOptions{CIDRPool: pool, SubnetPrefix: 28}
+		useFakeClient()
+
+		pc := newPodConfig("pc", newAttachment(func(na *Link) { na.CIDR = "" }))
+		pc.Default()
+
+		Expect(pc.Spec.NetworkAttachments[0].CIDR).To(Equal("10.100.0.0/28"))
+	})
+
+	It("leaves the cidr empty without a pool", func() {
+		pc := newPodConfig("pc", newAttachment(func(na *Link) { na.CIDR = "" }))
+		pc.Default()
+
+		Expect(pc.Spec.NetworkAttachments[0].CIDR).To(BeEmpty())
+	})
+
+	It("adds to the defaults recorded earlier", func() {
+		pc := newPodConfig("pc", Link{Name: "net1", Master: "br0", CIDR: "192.168.100.0/24"})
+		pc.Annotations = map[string]string{DefaultsAnnotation: "spec.networkAttachments[0].master=br0"}
+		pc.Default()
+
+		Expect(pc.Annotations).To(HaveKeyWithValue(DefaultsAnnotation,
+			"spec.networkAttachments[0].master=br0,spec.networkAttachments[0].linkType=veth"))
+	})
+})
@@ -0,0 +1,217 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PolicyViolations checks a podconfig against every policy selecting its
+// namespace. A podconfig has to satisfy all of them and namespaces not
+// selected by any policy are not restricted.
+func PolicyViolations(ctx context.Context, c client.Reader, podConfig *PodConfig) (field.ErrorList, error) {
+
+	var allErrs field.ErrorList
+
+	namespace := &corev1.Namespace{}
+	err := c.Get(ctx, client.ObjectKey{Name: podConfig.Namespace}, namespace)
+	if err != nil {
+		return allErrs, fmt.Errorf("failed to get namespace %s: %w", podConfig.Namespace, err)
+	}
+
+	policyList := &PodConfigPolicyList{}
+	err = c.List(ctx, policyList)
+	if err != nil {
+		return allErrs, fmt.Errorf("failed to list podconfig policies: %w", err)
+	}
+
+	for _, policy := range policyList.Items {
+		selected, err := policy.Selects(namespace)
+		if err != nil {
+			return allErrs, err
+		}
+		if selected {
+			allErrs = append(allErrs, policy.Check(podConfig)...)
+		}
+	}
+	return allErrs, nil
+}
+
+// Selects tells whether the policy applies to the namespace
+func (p *PodConfigPolicy) Selects(namespace *corev1.Namespace) (bool, error) {
+
+	if p.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespace selector in policy %s: %v", p.Name, err)
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// Check returns the podconfig fields not allowed by the policy
+func (p *PodConfigPolicy) Check(podConfig *PodConfig) field.ErrorList {
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	detail := fmt.Sprintf("not allowed by podconfig policy %s", p.Name)
+
+	// Pods get every attachment of the podconfig selecting them
+	if max := p.Spec.MaxAttachmentsPerPod; max != nil && len(podConfig.Spec.NetworkAttachments) > int(*max) {
+		allErrs = append(allErrs, field.TooMany(specPath.Child("networkAttachments"), len(podConfig.Spec.NetworkAttachments), int(*max)))
+	}
+
+	for i, na := range podConfig.Spec.NetworkAttachments {
+		naPath := specPath.Child("networkAttachments").Index(i)
+
+		if len(p.Spec.AllowedLinkTypes) > 0 && !containsString(p.Spec.AllowedLinkTypes, na.LinkType) {
+			allErrs = append(allErrs, field.Forbidden(naPath.Child("linkType"), detail))
+		}
+		if !matchesAny(p.Spec.AllowedBridges, na.Master) {
+			allErrs = append(allErrs, field.Forbidden(naPath.Child("master"), detail))
+		}
+		if na.Parent != "" && !matchesAny(p.Spec.AllowedParents, na.Parent) {
+			allErrs = append(allErrs, field.Forbidden(naPath.Child("parent"), detail))
+		}
+		if !p.allowsCIDR(na.CIDR) {
+			allErrs = append(allErrs, field.Forbidden(naPath.Child("cidr"), detail))
+		}
+		if na.Vlan != nil {
+			if na.Vlan.Access != 0 && !p.allowsVlan(na.Vlan.Access) {
+				allErrs = append(allErrs, field.Forbidden(naPath.Child("vlan", "access"), detail))
+			}
+			for j, vid := range na.Vlan.Trunk {
+				if !p.allowsVlan(vid) {
+					allErrs = append(allErrs, field.Forbidden(naPath.Child("vlan", "trunk").Index(j), detail))
+				}
+			}
+		}
+	}
+
+	for i, br := range podConfig.Spec.Bridges {
+		if !matchesAny(p.Spec.AllowedBridges, br.Name) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("bridges").Index(i).Child("name"), detail))
+		}
+	}
+
+	for i, vlan := range podConfig.Spec.Vlans {
+		vlanPath := specPath.Child("vlans").Index(i)
+
+		if !p.allowsVlan(vlan.VlanID) {
+			allErrs = append(allErrs, field.Forbidden(vlanPath.Child("vlanID"), detail))
+		}
+		if vlan.ParentInterfaceName != "" && !matchesAny(p.Spec.AllowedParents, vlan.ParentInterfaceName) {
+			allErrs = append(allErrs, field.Forbidden(vlanPath.Child("parentInterfaceName"), detail))
+		}
+		if vlan.BridgeName != "" && !matchesAny(p.Spec.AllowedBridges, vlan.BridgeName) {
+			allErrs = append(allErrs, field.Forbidden(vlanPath.Child("bridgeName"), detail))
+		}
+	}
+
+	for i, sysctl := range podConfig.Spec.Sysctls {
+		if !matchesAny(p.Spec.AllowedSysctls, sysctl.Name) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("sysctls").Index(i).Child("name"), detail))
+		}
+	}
+
+	for i, mount := range podConfig.Spec.Mounts {
+		if len(p.Spec.AllowedHostPaths) > 0 && !HostPathAllowed(mount.HostPath, p.Spec.AllowedHostPaths) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("mounts").Index(i).Child("hostPath"), detail))
+		}
+	}
+
+	for i, capability := range podConfig.Spec.Capabilities {
+		for j, name := range capability.Add {
+			if !p.allowsCapability(name) {
+				allErrs = append(allErrs, field.Forbidden(specPath.Child("capabilities").Index(i).Child("add").Index(j), detail))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// Capability names are compared in their normalized form
+func (p *PodConfigPolicy) allowsCapability(name string) bool {
+
+	if len(p.Spec.AllowedCapabilities) == 0 {
+		return true
+	}
+	for _, allowed := range p.Spec.AllowedCapabilities {
+		if CapabilityName(allowed) == CapabilityName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// The CIDR has to be fully inside one of the allowed networks
+func (p *PodConfigPolicy) allowsCIDR(cidr string) bool {
+
+	if len(p.Spec.AllowedCIDRs) == 0 {
+		return true
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := ipNet.Mask.Size()
+
+	for _, allowed := range p.Spec.AllowedCIDRs {
+		_, allowedNet, err := net.ParseCIDR(allowed)
+		if err != nil {
+			continue
+		}
+		allowedOnes, _ := allowedNet.Mask.Size()
+		if allowedNet.Contains(ipNet.IP) && ones >= allowedOnes {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PodConfigPolicy) allowsVlan(vid int16) bool {
+
+	if p.Spec.AllowedVlans == nil {
+		return true
+	}
+	return vid >= p.Spec.AllowedVlans.Min && vid <= p.Spec.AllowedVlans.Max
+}
+
+// Empty pattern lists match everything
+func matchesAny(patterns []string, name string) bool {
+
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPolicy(name string, spec PodConfigPolicySpec) *PodConfigPolicy {
+	return &PodConfigPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+var _ = Describe("PodConfigPolicy", func() {
+
+	tenants := &metav1.LabelSelector{MatchLabels: map[string]string{"podconfig.opdev.io/tenant": "true"}}
+	maxAttachments := int32(1)
+
+	restrictive := PodConfigPolicySpec{
+		AllowedLinkTypes:     []string{LinkTypeVeth},
+		AllowedCIDRs:         []string{"192.168.0.0/16"},
+		AllowedBridges:       []string{"pcbr*"},
+		AllowedParents:       []string{"eth1"},
+		AllowedVlans:         &VlanRange{Min: 100, Max: 199},
+		MaxAttachmentsPerPod: &maxAttachments,
+		AllowedSysctls:       []string{"net.ipv4.conf.*.forwarding"},
+		AllowedHostPaths:     []string{"/var/lib/cnf"},
+		AllowedCapabilities:  []string{"NET_RAW"},
+	}
+
+	allowed := func() *PodConfig {
+		pc := newPodConfig("pc", newAttachment(func(na *Link) {
+			na.Master = "pcbr0"
+			na.Parent = "eth1"
+			na.Vlan = &PortVlan{Access: 100, Trunk: []int16{150}}
+		}))
+		pc.Spec.Sysctls = []SysctlSpec{{Name: "net.ipv4.conf.net1.forwarding", Value: "1"}}
+		pc.Spec.Mounts = []MountSpec{{Target: TargetSpec{Container: "cnf"}, HostPath: "/var/lib/cnf/license", ContainerPath: "/etc/license"}}
+		pc.Spec.Capabilities = []CapabilitySpec{{Container: "cnf", Path: "/usr/bin/cnf-agent", Add: []string{"cap_net_raw"}}}
+		return pc
+	}
+
+	It("allows everything when its fields are empty", func() {
+		pc := allowed()
+		pc.Spec.NetworkAttachments = append(pc.Spec.NetworkAttachments, newAttachment(func(na *Link) { na.Name = "net2" }))
+		Expect(newPolicy("open", PodConfigPolicySpec{}).Check(pc)).To(BeEmpty())
+	})
+
+	DescribeTable("restricted fields",
+		func(modify func(pc *PodConfig), fields ...string) {
+			pc := allowed()
+			if modify != nil {
+				modify(pc)
+			}
+			errs := newPolicy("tenants", restrictive).Check(pc)
+			Expect(errorFields(errs)).To(ConsistOf(fields))
+			for _, err := range errs {
+				if err.Field != "spec.networkAttachments" {
+					Expect(err.Detail).To(Equal("not allowed by podconfig policy tenants"))
+				}
+			}
+		},
+		Entry("accepts an allowed podconfig", nil),
+		Entry("link type", func(pc *PodConfig) { pc.Spec.NetworkAttachments[0].LinkType = "macvlan" },
+			"spec.networkAttachments[0].linkType"),
+		Entry("cidr outside the allowed networks", func(pc *PodConfig) { pc.Spec.NetworkAttachments[0].CIDR = "10.0.0.0/24" },
+			"spec.networkAttachments[0].cidr"),
+		Entry("cidr larger than the allowed networks", func(pc *PodConfig) { pc.Spec.NetworkAttachments[0].CIDR = "192.0.0.0/8" },
+			"spec.networkAttachments[0].cidr"),
+		Entry("bridge names not matching the patterns", func(pc *PodConfig) {
+			pc.Spec.NetworkAttachments[0].Master = "br0"
+			pc.Spec.Bridges = []BridgeSpec{{Name: "br0"}}
+		}, "spec.networkAttachments[0].master", "spec.bridges[0].name"),
+		Entry("parent interfaces", func(pc *PodConfig) { pc.Spec.NetworkAttachments[0].Parent = "eth0" },
+			"spec.networkAttachments[0].parent"),
+		Entry("vlans out of range", func(pc *PodConfig) {
+			pc.Spec.NetworkAttachments[0].Vlan = &PortVlan{Access: 10, Trunk: []int16{100, 200}}
+		}, "spec.networkAttachments[0].vlan.access", "spec.networkAttachments[0].vlan.trunk[1]"),
+		Entry("vlan subinterfaces", func(pc *PodConfig) {
+			pc.Spec.Vlans = []VlanSpec{{ParentInterfaceName: "eth0", VlanID: 300, BridgeName: "br0"}}
+		}, "spec.vlans[0].vlanID", "spec.vlans[0].parentInterfaceName", "spec.vlans[0].bridgeName"),
+		Entry("attachments per pod", func(pc *PodConfig) {
+			pc.Spec.NetworkAttachments = append(pc.Spec.NetworkAttachments, pc.Spec.NetworkAttachments[0])
+		}, "spec.networkAttachments"),
+		Entry("sysctl names not matching the patterns", func(pc *PodConfig) {
+			pc.Spec.Sysctls = append(pc.Spec.Sysctls, SysctlSpec{Name: "net.core.somaxconn", Value: "1024"})
+		}, "spec.sysctls[1].name"),
+		Entry("mount host paths outside the allowed paths", func(pc *PodConfig) {
+			pc.Spec.Mounts[0].HostPath = "/etc/cnf"
+		}, "spec.mounts[0].hostPath"),
+		Entry("capabilities not in the allowed ones", func(pc *PodConfig) {
+			pc.Spec.Capabilities[0].Add = append(pc.Spec.Capabilities[0].Add, "SYS_ADMIN")
+		}, "spec.capabilities[0].add[1]"),
+	)
+
+	Describe("namespace selection", func() {
+
+		It("selects every namespace without a selector", func() {
+			selected, err := newPolicy("all", PodConfigPolicySpec{}).Selects(newNamespace("default", nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selected).To(BeTrue())
+		})
+
+		It("selects the namespaces matching its selector", func() {
+			policy := newPolicy("tenants", PodConfigPolicySpec{NamespaceSelector: tenants})
+
+			selected, err := policy.Selects(newNamespace("tenant", map[string]string{"podconfig.opdev.io/tenant": "true"}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selected).To(BeTrue())
+
+			selected, err = policy.Selects(newNamespace("default", nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selected).To(BeFalse())
+		})
+	})
+
+	Describe("violations", func() {
+
+		var c client.Client
+
+		BeforeEach(func() {
+			linkTypes := restrictive
+			linkTypes.NamespaceSelector = tenants
+			cidrs := PodConfigPolicySpec{AllowedCIDRs: []string{"192.168.100.0/24"}}
+
+			c = fake.NewFakeClientWithScheme(testScheme, []runtime.Object{
+				newNamespace("default", nil),
+				newNamespace("tenant", map[string]string{"podconfig.opdev.io/tenant": "true"}),
+				newPolicy("tenants", linkTypes),
+				newPolicy("cidrs", cidrs),
+			}...)
+		})
+
+		It("checks every policy selecting the namespace", func() {
+			pc := allowed()
+			pc.Namespace = "tenant"
+			pc.Spec.NetworkAttachments[0].CIDR = "192.168.99.0/24"
+
+			errs, err := PolicyViolations(context.TODO(), c, pc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errorFields(errs)).To(ConsistOf("spec.networkAttachments[0].cidr"))
+			Expect(errs[0].Detail).To(Equal("not allowed by podconfig policy cidrs"))
+		})
+
+		It("ignores policies not selecting the namespace", func() {
+			pc := allowed()
+			pc.Spec.NetworkAttachments[0].LinkType = "macvlan"
+
+			errs, err := PolicyViolations(context.TODO(), c, pc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errs).To(BeEmpty())
+		})
+	})
+
+	Describe("admission", func() {
+
+		var admitted *PodConfig
+
+		BeforeEach(func() {
+			// Admitted before the policy restricted the cidrs
+			admitted = allowed()
+			admitted.Spec.NetworkAttachments[0].CIDR = "10.0.0.0/24"
+			admitted.Finalizers = []string{"podconfig.finalizers.opdev.io"}
+
+			useFakeClient(newNamespace("default", nil), newPolicy("cidrs", PodConfigPolicySpec{AllowedCIDRs: []string{"192.168.0.0/16"}}))
+			webhookOptions.AllowedHostPaths = []string{"/var/lib/cnf"}
+			webhookOptions.AllowedCapabilities = []string{"NET_RAW"}
+		})
+
+		AfterEach(func() {
+			webhookClient = nil
+			webhookOptions = WebhookOptions{}
+		})
+
+		It("rejects podconfigs violating a policy", func() {
+			err := admitted.ValidateCreate()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("not allowed by podconfig policy cidrs"))
+		})
+
+		It("lets the finalizer of a podconfig held back by a policy be removed", func() {
+			updated := admitted.DeepCopy()
+			now := metav1.Now()
+			updated.DeletionTimestamp = &now
+			updated.Finalizers = nil
+
+			Expect(updated.ValidateUpdate(admitted)).To(Succeed())
+		})
+
+		It("lets metadata and status updates through while the spec is unchanged", func() {
+			updated := admitted.DeepCopy()
+			updated.Labels = map[string]string{"app": "cnf"}
+			updated.Status.Phase = "Pending"
+
+			Expect(updated.ValidateUpdate(admitted)).To(Succeed())
+		})
+
+		It("rejects spec changes until the podconfig satisfies the policy", func() {
+			updated := admitted.DeepCopy()
+			updated.Spec.Sysctls = nil
+
+			err := updated.ValidateUpdate(admitted)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("not allowed by podconfig policy cidrs"))
+
+			updated.Spec.NetworkAttachments[0].CIDR = "192.168.1.0/24"
+			Expect(updated.ValidateUpdate(admitted)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,85 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VlanRange type for the VLAN IDs allowed by a policy
+type VlanRange struct {
+	Min int16 `json:"min"`
+	Max int16 `json:"max"`
+}
+
+// PodConfigPolicySpec defines what podconfigs of the selected namespaces may request.
+// Empty fields don't restrict anything.
+type PodConfigPolicySpec struct {
+	// Namespaces the policy applies to, all namespaces when not set
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Link types network attachments may use
+	AllowedLinkTypes []string `json:"allowedLinkTypes,omitempty"`
+
+	// Networks the network attachment CIDRs must be part of
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+
+	// Master bridge names, shell patterns such as pcbr* are accepted
+	AllowedBridges []string `json:"allowedBridges,omitempty"`
+
+	// Parent host interfaces for network attachments and vlans, shell patterns are accepted
+	AllowedParents []string `json:"allowedParents,omitempty"`
+
+	// VLAN IDs bridge ports and vlan subinterfaces may use
+	AllowedVlans *VlanRange `json:"allowedVlans,omitempty"`
+
+	// Maximum number of network attachments configured on a single pod
+	MaxAttachmentsPerPod *int32 `json:"maxAttachmentsPerPod,omitempty"`
+
+	// Sysctls pods may get, shell patterns such as net.ipv4.conf.*.forwarding are accepted
+	AllowedSysctls []string `json:"allowedSysctls,omitempty"`
+
+	// Host paths mounts may use, along with everything below them
+	AllowedHostPaths []string `json:"allowedHostPaths,omitempty"`
+
+	// Capabilities binaries may get, such as NET_RAW
+	AllowedCapabilities []string `json:"allowedCapabilities,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// PodConfigPolicy is the Schema for the podconfigpolicies API
+type PodConfigPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PodConfigPolicySpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodConfigPolicyList contains a list of PodConfigPolicy
+type PodConfigPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodConfigPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodConfigPolicy{}, &PodConfigPolicyList{})
+}
@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceUsage sums up the host resources requested by the podconfigs of a
+// namespace across all nodes. When candidate is set it takes the place of the
+// stored podconfig with the same name, or is added when there is none.
+func NamespaceUsage(ctx context.Context, c client.Client, namespace string, candidate *PodConfig) (QuotaUsage, error) {
+
+	usage := QuotaUsage{}
+
+	podConfigList := &PodConfigList{}
+	err := c.List(ctx, podConfigList, client.InNamespace(namespace))
+	if err != nil {
+		return usage, fmt.Errorf("failed to list podconfigs: %w", err)
+	}
+
+	podConfigs := []PodConfig{}
+	for _, podConfig := range podConfigList.Items {
+		if candidate != nil && podConfig.Name == candidate.Name {
+			continue
+		}
+		podConfigs = append(podConfigs, podConfig)
+	}
+	if candidate != nil {
+		podConfigs = append(podConfigs, *candidate)
+	}
+
+	bridges := map[string]bool{}
+	gateways := map[string]bool{}
+	for _, podConfig := range podConfigs {
+
+		pods, err := countSelectedPods(ctx, c, podConfig)
+		if err != nil {
+			return usage, err
+		}
+
+		for _, na := range podConfig.Spec.NetworkAttachments {
+			usage.Attachments += pods
+			usage.Addresses += pods
+
+			bridges[na.Master] = true
+			if na.Gateway == nil || na.Gateway.Mode != GatewayNone {
+				gateways[na.Master] = true
+			}
+		}
+	}
+	usage.Bridges = int32(len(bridges))
+	usage.Addresses += int32(len(gateways))
+
+	return usage, nil
+}
+
+// Pods are selected by the podconfig label, finished pods don't hold any resources
+func countSelectedPods(ctx context.Context, c client.Client, podConfig PodConfig) (int32, error) {
+
+	podList := &corev1.PodList{}
+	err := c.List(ctx, podList, client.InNamespace(podConfig.Namespace), client.MatchingLabels{"podconfig": podConfig.Name})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var count int32
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Exceeded returns the limits the usage goes over
+func (q *PodConfigQuota) Exceeded(usage QuotaUsage) field.ErrorList {
+
+	var allErrs field.ErrorList
+	hardPath := field.NewPath("spec", "hard")
+
+	check := func(name string, limit *int32, used int32) {
+		if limit != nil && used > *limit {
+			allErrs = append(allErrs, field.Forbidden(hardPath.Child(name),
+				fmt.Sprintf("exceeded podconfig quota %s: requested %d, limited to %d", q.Name, used, *limit)))
+		}
+	}
+	check("attachments", q.Spec.Hard.Attachments, usage.Attachments)
+	check("bridges", q.Spec.Hard.Bridges, usage.Bridges)
+	check("addresses", q.Spec.Hard.Addresses, usage.Addresses)
+
+	return allErrs
+}
+
+// QuotaViolations checks the usage of a namespace against its quotas
+func QuotaViolations(ctx context.Context, c client.Client, namespace string, usage QuotaUsage) (field.ErrorList, error) {
+
+	var allErrs field.ErrorList
+
+	quotaList := &PodConfigQuotaList{}
+	err := c.List(ctx, quotaList, client.InNamespace(namespace))
+	if err != nil {
+		return allErrs, fmt.Errorf("failed to list podconfig quotas: %w", err)
+	}
+
+	for _, quota := range quotaList.Items {
+		allErrs = append(allErrs, quota.Exceeded(usage)...)
+	}
+	return allErrs, nil
+}
@@ -0,0 +1,135 @@
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPod(name string, podConfig string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"podconfig": podConfig}},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func newQuota(name string, attachments, bridges, addresses int32) *PodConfigQuota {
+	return &PodConfigQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: PodConfigQuotaSpec{Hard: QuotaLimits{
+			Attachments: &attachments,
+			Bridges:     &bridges,
+			Addresses:   &addresses,
+		}},
+	}
+}
+
+var _ = Describe("PodConfigQuota", func() {
+
+	var objects []runtime.Object
+
+	// Two running pods and a finished one get two attachments on two
+	// bridges, one of them L2 only
+	BeforeEach(func() {
+		pc := newPodConfig("pc",
+			newAttachment(nil),
+			newAttachment(func(na *Link) {
+				na.Name = "net2"
+				na.Master = "br1"
+				na.CIDR = "192.168.101.0/24"
+				na.Gateway = &GatewaySpec{Mode: GatewayNone}
+			}))
+
+		objects = []runtime.Object{
+			newNamespace("default", nil),
+			pc,
+			newPod("pod-a", "pc", corev1.PodRunning),
+			newPod("pod-b", "pc", corev1.PodPending),
+			newPod("pod-c", "pc", corev1.PodSucceeded),
+		}
+	})
+
+	AfterEach(func() {
+		webhookClient = nil
+		webhookOptions = WebhookOptions{}
+	})
+
+	Describe("usage", func() {
+
+		It("counts the attachments and addresses of the selected pods", func() {
+			c := fake.NewFakeClientWithScheme(testScheme, objects...)
+
+			usage, err := NamespaceUsage(context.TODO(), c, "default", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(Equal(QuotaUsage{Attachments: 4, Bridges: 2, Addresses: 5}))
+		})
+
+		It("counts a candidate in place of the podconfig it replaces", func() {
+			c := fake.NewFakeClientWithScheme(testScheme, objects...)
+
+			usage, err := NamespaceUsage(context.TODO(), c, "default", newPodConfig("pc", newAttachment(nil)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage).To(Equal(QuotaUsage{Attachments: 2, Bridges: 1, Addresses: 3}))
+		})
+	})
+
+	It("reports every limit the usage goes over", func() {
+		errs := newQuota("small", 4, 1, 4).Exceeded(QuotaUsage{Attachments: 4, Bridges: 2, Addresses: 5})
+
+		Expect(errorFields(errs)).To(ConsistOf("spec.hard.bridges", "spec.hard.addresses"))
+		Expect(errs[0].Detail).To(Equal("exceeded podconfig quota small: requested 2, limited to 1"))
+	})
+
+	Describe("admission", func() {
+
+		It("rejects new podconfigs growing the usage over a quota", func() {
+			useFakeClient(append(objects,
+				newQuota("quota", 10, 2, 10), newPod("pod-d", "other", corev1.PodRunning))...)
+
+			other := newPodConfig("other", newAttachment(func(na *Link) {
+				na.Master = "br2"
+				na.CIDR = "192.168.102.0/24"
+			}))
+			err := other.ValidateCreate()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("exceeded podconfig quota quota: requested 3, limited to 2"))
+		})
+
+		Context("when pods were scaled up past a quota", func() {
+
+			var old *PodConfig
+
+			BeforeEach(func() {
+				useFakeClient(append(objects, newQuota("quota", 2, 2, 10))...)
+				old = objects[1].(*PodConfig)
+			})
+
+			It("accepts updates that don't grow the usage", func() {
+				updated := old.DeepCopy()
+				updated.Spec.NetworkAttachments[0].Vlan = &PortVlan{Access: 100}
+				Expect(updated.ValidateUpdate(old)).To(Succeed())
+
+				updated.Spec.NetworkAttachments = updated.Spec.NetworkAttachments[:1]
+				Expect(updated.ValidateUpdate(old)).To(Succeed())
+			})
+
+			It("rejects updates that grow the usage", func() {
+				updated := old.DeepCopy()
+				updated.Spec.NetworkAttachments = append(updated.Spec.NetworkAttachments, newAttachment(func(na *Link) {
+					na.Name = "net3"
+					na.CIDR = "192.168.102.0/24"
+				}))
+
+				err := updated.ValidateUpdate(old)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("exceeded podconfig quota quota: requested 6, limited to 2"))
+			})
+		})
+	})
+})
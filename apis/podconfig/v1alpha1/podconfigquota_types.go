@@ -0,0 +1,76 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaLimits type for the host resources a namespace may use, unset fields are not limited
+type QuotaLimits struct {
+	// Network attachments summed over every selected pod
+	Attachments *int32 `json:"attachments,omitempty"`
+
+	// Distinct master bridges
+	Bridges *int32 `json:"bridges,omitempty"`
+
+	// Pod addresses plus bridge gateway addresses
+	Addresses *int32 `json:"addresses,omitempty"`
+}
+
+// QuotaUsage type for the host resources requested by the podconfigs of a namespace
+type QuotaUsage struct {
+	Attachments int32 `json:"attachments"`
+	Bridges     int32 `json:"bridges"`
+	Addresses   int32 `json:"addresses"`
+}
+
+// PodConfigQuotaSpec defines the desired state of PodConfigQuota
+type PodConfigQuotaSpec struct {
+	Hard QuotaLimits `json:"hard"`
+}
+
+// PodConfigQuotaStatus defines the observed state of PodConfigQuota
+type PodConfigQuotaStatus struct {
+	Used QuotaUsage `json:"used,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=podconfigquotas
+// +kubebuilder:subresource:status
+
+// PodConfigQuota is the Schema for the podconfigquotas API
+type PodConfigQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodConfigQuotaSpec   `json:"spec"`
+	Status PodConfigQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodConfigQuotaList contains a list of PodConfigQuota
+type PodConfigQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodConfigQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodConfigQuota{}, &PodConfigQuotaList{})
+}
@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Extended attribute holding the file capabilities of a binary
+const capabilityXattr = "security.capability"
+
+// Revision 2 file capabilities, 64 bit permitted and inheritable sets,
+// with the effective bit raising the permitted set on exec
+const (
+	vfsCapRevision2      = 0x02000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// AllowedCapabilities podconfigs may set on container binaries, checked
+// again before every change like the allowed host paths
+var AllowedCapabilities []string
+
+// Whether a capability is one of the allowed ones
+func capabilityAllowed(name string) bool {
+	for _, allowed := range AllowedCapabilities {
+		if podconfigv1alpha1.CapabilityName(allowed) == podconfigv1alpha1.CapabilityName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the file capabilities of the podconfig on container binaries and
+// reverts the ones recorded for the pod that are no longer in the spec.
+// Capabilities are checked again on every run, since they are lost with
+// the writable layer when a container restarts.
+func applyCapabilities(pod corev1.Pod, capabilities []podconfigv1alpha1.CapabilitySpec, recorded []podconfigv1alpha1.FileCapabilityStatus, event eventFunc) ([]podconfigv1alpha1.FileCapabilityStatus, error) {
+
+	applied := []podconfigv1alpha1.FileCapabilityStatus{}
+	if len(capabilities) == 0 && len(recorded) == 0 {
+		return applied, nil
+	}
+
+	conn, err := getCRIOConnection()
+	if err != nil {
+		return applied, fmt.Errorf("Error getting CRIO connection: %v", err)
+	}
+	defer conn.Close()
+
+	removed := []podconfigv1alpha1.FileCapabilityStatus{}
+	for _, r := range recorded {
+		if findCapabilitySpec(capabilities, r.Container, r.Path) == nil {
+			removed = append(removed, r)
+		}
+	}
+	if err := revertFileCapabilities(pod, removed, conn, event); err != nil {
+		return applied, err
+	}
+
+	// Every binary is checked before any is changed, so that a failure
+	// doesn't leave capabilities set that aren't recorded
+	type pending struct {
+		status podconfigv1alpha1.FileCapabilityStatus
+		pid    string
+		value  []byte
+	}
+	changes := []pending{}
+	for _, capability := range capabilities {
+
+		status := podconfigv1alpha1.FileCapabilityStatus{Container: capability.Container, Path: capability.Path}
+		for _, name := range capability.Add {
+			if !capabilityAllowed(name) {
+				return applied, fmt.Errorf("capability %v is not allowed", name)
+			}
+			status.Add = append(status.Add, podconfigv1alpha1.CapabilityName(name))
+		}
+
+		pid, err := resolveTarget(pod, podconfigv1alpha1.TargetSpec{Container: capability.Container}, conn)
+		if err != nil {
+			return applied, err
+		}
+
+		// Execs of a binary with file capabilities outside the bounding
+		// set fail, they are refused instead
+		value, err := fileCapabilities(pid, status.Add)
+		if err != nil {
+			return applied, fmt.Errorf("container %v: %v", capability.Container, err)
+		}
+		changes = append(changes, pending{status: status, pid: pid, value: value})
+	}
+
+	for _, change := range changes {
+
+		status := change.status
+		isRecorded := false
+		for _, r := range recorded {
+			if r.Container == status.Container && r.Path == status.Path {
+				status.Previous, isRecorded = r.Previous, true
+			}
+		}
+
+		changed := false
+		err := doInMountNS(change.pid, func() error {
+			info, err := os.Stat(status.Path)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%v is not a regular file", status.Path)
+			}
+			current, err := getCapabilityXattr(status.Path)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(current, change.value) {
+				return nil
+			}
+			// The attribute found the first time is the one restored
+			if !isRecorded {
+				status.Previous = hex.EncodeToString(current)
+			}
+			changed = true
+			return unix.Setxattr(status.Path, capabilityXattr, change.value, 0)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set capabilities of %v in container %v: %v", status.Path, status.Container, err)
+		}
+		if changed {
+			event(corev1.EventTypeNormal, reasonCapabilitiesSet, "Set capabilities %v on %s in container %s",
+				strings.Join(status.Add, ","), status.Path, status.Container)
+		}
+		applied = append(applied, status)
+	}
+	return applied, nil
+}
+
+// Restores the file capabilities binaries had before they were set. Failures
+// are returned so that the revert is retried and the podconfig kept.
+func revertFileCapabilities(pod corev1.Pod, recorded []podconfigv1alpha1.FileCapabilityStatus, conn *grpc.ClientConn, event eventFunc) error {
+
+	for _, r := range recorded {
+
+		// A container that is restarting gets them reverted once running
+		pid, err := resolveTarget(pod, podconfigv1alpha1.TargetSpec{Container: r.Container}, conn)
+		if err != nil {
+			event(corev1.EventTypeWarning, reasonRevertFailed, "Can't revert capabilities of %s in container %s: %v", r.Path, r.Container, err)
+			return err
+		}
+
+		previous, err := hex.DecodeString(r.Previous)
+		if err != nil {
+			return fmt.Errorf("invalid recorded capabilities of %v: %v", r.Path, err)
+		}
+		err = doInMountNS(pid, func() error {
+			if len(previous) > 0 {
+				return unix.Setxattr(r.Path, capabilityXattr, previous, 0)
+			}
+			err := unix.Removexattr(r.Path, capabilityXattr)
+			if err == unix.ENODATA || err == unix.ENOENT {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			event(corev1.EventTypeWarning, reasonRevertFailed, "Failed to revert capabilities of %s in container %s: %v", r.Path, r.Container, err)
+			return fmt.Errorf("failed to revert capabilities of %v in container %v: %v", r.Path, r.Container, err)
+		}
+		event(corev1.EventTypeNormal, reasonCapabilitiesReverted, "Reverted capabilities %v of %s in container %s",
+			strings.Join(r.Add, ","), r.Path, r.Container)
+	}
+	return nil
+}
+
+// Reverts every file capability recorded for a pod that is still running
+func revertCapabilities(pod corev1.Pod, recorded []podconfigv1alpha1.FileCapabilityStatus, event eventFunc) error {
+
+	if len(recorded) == 0 || pod.Status.Phase != corev1.PodRunning {
+		return nil
+	}
+
+	conn, err := getCRIOConnection()
+	if err != nil {
+		return fmt.Errorf("Error getting CRIO connection: %v", err)
+	}
+	defer conn.Close()
+
+	return revertFileCapabilities(pod, recorded, conn, event)
+}
+
+func findCapabilitySpec(capabilities []podconfigv1alpha1.CapabilitySpec, container string, path string) *podconfigv1alpha1.CapabilitySpec {
+	for i := range capabilities {
+		if capabilities[i].Container == container && capabilities[i].Path == path {
+			return &capabilities[i]
+		}
+	}
+	return nil
+}
+
+// Builds the security.capability attribute granting the capabilities in
+// the permitted and effective sets. Capabilities outside the bounding set
+// of the process are refused.
+func fileCapabilities(pid string, names []string) ([]byte, error) {
+
+	bounding, err := boundingSet(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var permitted uint64
+	for _, name := range names {
+		number, ok := podconfigv1alpha1.CapabilityNumber(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %v", name)
+		}
+		if bounding&(1<<number) == 0 {
+			return nil, fmt.Errorf("capability %v is not in the bounding set, add it to the container security context", name)
+		}
+		permitted |= 1 << number
+	}
+
+	value := make([]byte, 20)
+	binary.LittleEndian.PutUint32(value[0:], vfsCapRevision2|vfsCapFlagsEffective)
+	binary.LittleEndian.PutUint32(value[4:], uint32(permitted))
+	binary.LittleEndian.PutUint32(value[12:], uint32(permitted>>32))
+	return value, nil
+}
+
+// Capability bounding set of a process, from its status file
+func boundingSet(pid string) (uint64, error) {
+
+	status, err := os.Open(filepath.Join(HostProc, pid, "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer status.Close()
+
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "CapBnd:"); value != scanner.Text() {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no bounding set in the status of process %v", pid)
+}
+
+// The security.capability attribute of a file, nil when it has none
+func getCapabilityXattr(path string) ([]byte, error) {
+
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(path, capabilityXattr, value)
+	if err == unix.ENODATA {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value[:n], nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+)
+
+var _ = Describe("File capabilities", func() {
+
+	Describe("encoding", func() {
+
+		var proc string
+
+		BeforeEach(func() {
+			var err error
+			proc, err = ioutil.TempDir("", "proc")
+			Expect(err).NotTo(HaveOccurred())
+			HostProc = proc
+
+			// Process 1 has the default container bounding set with
+			// NET_RAW, process 2 every capability and process 3 no
+			// status line for it
+			statuses := map[string]string{
+				"1": "Name:\tcnf\nCapInh:\t0000000000000000\nCapBnd:\t00000000a80425fb\nCapAmb:\t0000000000000000\n",
+				"2": "Name:\tcnf\nCapBnd:\t000001ffffffffff\n",
+				"3": "Name:\tcnf\n",
+			}
+			for pid, status := range statuses {
+				Expect(os.MkdirAll(filepath.Join(proc, pid), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(proc, pid, "status"), []byte(status), 0644)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(proc)
+			HostProc = "/tmp/proc"
+		})
+
+		// Revision 2 header with the effective flag, then the permitted
+		// and inheritable sets of the low and high capability words
+		value := func(permittedLow, permittedHigh byte) []byte {
+			return []byte{
+				0x01, 0x00, 0x00, 0x02,
+				0x00, permittedLow, 0x00, 0x00, 0, 0, 0, 0,
+				permittedHigh, 0x00, 0x00, 0x00, 0, 0, 0, 0,
+			}
+		}
+
+		DescribeTable("set the permitted capabilities of the binary",
+			func(pid string, names []string, expected []byte) {
+				Expect(fileCapabilities(pid, names)).To(Equal(expected))
+			},
+			Entry("for a single capability", "1", []string{"NET_RAW"}, value(0x20, 0x00)),
+			Entry("for names in any form", "2", []string{"cap_net_raw", "CAP_IPC_LOCK"}, value(0x60, 0x00)),
+			Entry("for capabilities above 31", "2", []string{"BPF"}, value(0x00, 0x80)),
+		)
+
+		DescribeTable("refuse capabilities the container can't get",
+			func(pid string, names []string) {
+				_, err := fileCapabilities(pid, names)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("outside its bounding set", "1", []string{"NET_RAW", "SYS_ADMIN"}),
+			Entry("unknown", "2", []string{"NOT_A_CAP"}),
+			Entry("without a bounding set", "3", []string{"NET_RAW"}),
+			Entry("without a process", "4", []string{"NET_RAW"}),
+		)
+	})
+
+	DescribeTable("are checked against the allowed capabilities",
+		func(allowed []string, name string, expected bool) {
+			AllowedCapabilities = allowed
+			defer func() { AllowedCapabilities = nil }()
+
+			Expect(capabilityAllowed(name)).To(Equal(expected))
+		},
+		Entry("in lower case", []string{"NET_RAW"}, "net_raw", true),
+		Entry("with the CAP_ prefix", []string{"cap_net_raw"}, "NET_RAW", true),
+		Entry("not allowed", []string{"NET_RAW"}, "SYS_ADMIN", false),
+		Entry("without allowed capabilities", nil, "NET_RAW", false),
+	)
+
+	It("are matched to their spec by container and path", func() {
+		capabilities := []podconfigv1alpha1.CapabilitySpec{
+			{Container: "cnf", Path: "/usr/bin/cnf-agent", Add: []string{"NET_RAW"}},
+		}
+		Expect(findCapabilitySpec(capabilities, "cnf", "/usr/bin/cnf-agent")).To(Equal(&capabilities[0]))
+		Expect(findCapabilitySpec(capabilities, "sidecar", "/usr/bin/cnf-agent")).To(BeNil())
+	})
+})
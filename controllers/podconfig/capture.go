@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Link type of the pcap files. Captures are taken on raw packet sockets,
+// frames have the link header of their interface and pod interfaces are
+// veths or the loopback, which have Ethernet headers.
+const pcapLinkTypeEthernet = 1
+
+// Longest filter the kernel accepts
+const bpfMaxInstructions = 4096
+
+// Compiles a tcpdump filter expression to classic BPF with the tcpdump of
+// the operator image. The loopback link type is Ethernet, like the pod
+// interfaces the filter is attached on.
+func compileFilter(expression string, snapLength int) ([]unix.SockFilter, error) {
+
+	if expression == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "tcpdump", "-i", "lo", "-s", strconv.Itoa(snapLength), "-ddd", "--", expression)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v %s", expression, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseFilter(out)
+}
+
+// Parses the decimal output of tcpdump -ddd, an instruction count followed
+// by one "code jt jf k" line per instruction
+func parseFilter(out []byte) ([]unix.SockFilter, error) {
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty compiled filter")
+	}
+	count, err := strconv.Atoi(scanner.Text())
+	if err != nil || count < 1 || count > bpfMaxInstructions {
+		return nil, fmt.Errorf("invalid compiled filter length %q", scanner.Text())
+	}
+
+	filter := make([]unix.SockFilter, 0, count)
+	for scanner.Scan() {
+		var instruction unix.SockFilter
+		_, err := fmt.Sscan(scanner.Text(), &instruction.Code, &instruction.Jt, &instruction.Jf, &instruction.K)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compiled filter instruction %q: %v", scanner.Text(), err)
+		}
+		filter = append(filter, instruction)
+	}
+	if len(filter) != count {
+		return nil, fmt.Errorf("compiled filter has %d instructions instead of %d", len(filter), count)
+	}
+	return filter, scanner.Err()
+}
+
+// Opens a packet socket in a pod network namespace, on one interface or on
+// all of them when name is empty. The socket stays in the namespace it was
+// created in and can be read from any thread.
+func openCaptureSocket(netNSPath string, name string, filter []unix.SockFilter) (int, error) {
+
+	fd := -1
+	err := collectInNetNS(netNSPath, func() error {
+
+		ifindex := 0
+		if name != "" {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return err
+			}
+			ifindex = iface.Index
+		}
+
+		// No packets are queued before the socket is bound to a protocol,
+		// which lets the filter be attached first
+		socket, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+
+		if len(filter) > 0 {
+			program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+			err = unix.SetsockoptSockFprog(socket, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &program)
+		}
+		if err == nil {
+			err = unix.Bind(socket, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex})
+		}
+		// Reads time out to check for the end of the capture
+		if err == nil {
+			err = unix.SetsockoptTimeval(socket, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Usec: 500000})
+		}
+		if err != nil {
+			unix.Close(socket)
+			return err
+		}
+		fd = socket
+		return nil
+	})
+	return fd, err
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// captureResult of a finished capture
+type captureResult struct {
+	Packets int64
+	Dropped int64
+	Bytes   int64
+	Reason  string // why the capture stopped
+}
+
+// Reads packets from a capture socket into a pcap file until the context
+// is done or the file would grow over maxBytes. The socket is closed on
+// return.
+func runCapture(ctx context.Context, fd int, w io.Writer, snapLength int, maxBytes int64) (captureResult, error) {
+
+	defer unix.Close(fd)
+
+	result := captureResult{}
+	out := bufio.NewWriter(w)
+
+	// pcap global header, version 2.4 with microsecond timestamps
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], uint32(snapLength))
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeEthernet)
+	if _, err := out.Write(header); err != nil {
+		return result, err
+	}
+	result.Bytes = int64(len(header))
+
+	packet := make([]byte, snapLength)
+	record := make([]byte, 16)
+	for {
+		if ctx.Err() != nil {
+			result.Reason = "duration elapsed"
+			break
+		}
+
+		// The real length of truncated packets is returned
+		n, _, err := unix.Recvfrom(fd, packet, unix.MSG_TRUNC)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		captured := n
+		if captured > len(packet) {
+			captured = len(packet)
+		}
+
+		if result.Bytes+int64(len(record)+captured) > maxBytes {
+			result.Reason = "size limit reached"
+			break
+		}
+
+		now := time.Now()
+		binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+		binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:], uint32(captured))
+		binary.LittleEndian.PutUint32(record[12:], uint32(n))
+		if _, err := out.Write(record); err != nil {
+			return result, err
+		}
+		if _, err := out.Write(packet[:captured]); err != nil {
+			return result, err
+		}
+		result.Packets++
+		result.Bytes += int64(len(record) + captured)
+	}
+
+	if stats, err := unix.GetsockoptTpacketStats(fd, unix.SOL_PACKET, unix.PACKET_STATISTICS); err == nil {
+		result.Dropped = int64(stats.Drops)
+	}
+	return result, out.Flush()
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Capture filters", func() {
+
+	It("are parsed from the tcpdump -ddd output", func() {
+		filter, err := parseFilter([]byte("6\n40 0 0 12\n21 0 3 2048\n48 0 0 23\n21 0 1 1\n6 0 0 262144\n6 0 0 0\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter).To(Equal([]unix.SockFilter{
+			{Code: 40, K: 12}, {Code: 21, Jf: 3, K: 2048}, {Code: 48, K: 23},
+			{Code: 21, Jf: 1, K: 1}, {Code: 6, K: 262144}, {Code: 6},
+		}))
+	})
+
+	It("may be as long as the kernel accepts", func() {
+		filter, err := parseFilter([]byte(fmt.Sprintf("%d\n", bpfMaxInstructions) + strings.Repeat("6 0 0 0\n", bpfMaxInstructions)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter).To(HaveLen(bpfMaxInstructions))
+	})
+
+	DescribeTable("refuse malformed output",
+		func(out string) {
+			_, err := parseFilter([]byte(out))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("without instructions", "0\n"),
+		Entry("too long", fmt.Sprintf("%d\n", bpfMaxInstructions+1)),
+		Entry("without a count", "six\n6 0 0 0\n"),
+		Entry("with missing instructions", "2\n6 0 0 0\n"),
+		Entry("with extra instructions", "1\n6 0 0 0\n6 0 0 0\n"),
+		Entry("with a malformed instruction", "1\n6 0 0\n"),
+		Entry("with a jump offset out of range", "1\n21 256 0 0\n"),
+		Entry("with a negative constant", "1\n6 0 0 -1\n"),
+	)
+})
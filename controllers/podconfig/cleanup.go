@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Removes the host side of the attachments selected by owner without a pod
+// pid. Host veths are found by their alias and deleting them deletes their
+// pod peer as well, when the pod network namespace still exists. Addresses
+// are released by owner and the bridges are deleted once they have no
+// ports left.
+func cleanupHostState(owner attachmentOwner, bridges []string, event eventFunc) error {
+
+	err := doInNetNS(hostNetNSPath(), func() error {
+
+		links, err := netlink.LinkList()
+		if err != nil {
+			return fmt.Errorf("failed to list host links: %v", err)
+		}
+
+		for _, link := range links {
+			linkOwner, _, ok := parsePortAlias(link.Attrs().Alias)
+			if !ok || !owner.selects(linkOwner) {
+				continue
+			}
+			if err := deleteLinkByName(link.Attrs().Name); err != nil {
+				return err
+			}
+			event(corev1.EventTypeNormal, reasonInterfaceDeleted, "Deleted interface %s of pod %s", link.Attrs().Name, linkOwner.Pod)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Addresses of pods whose veths already went away with their network namespace
+	for _, alias := range ips.Owners() {
+		ipOwner, _, ok := parsePortAlias(alias)
+		if ok && owner.selects(ipOwner) {
+			ips.ReleaseOwner(alias)
+		}
+	}
+	for alias := range interfaces.list() {
+		ifaceOwner, _, ok := parsePortAlias(alias)
+		if ok && owner.selects(ifaceOwner) {
+			interfaces.remove(alias)
+		}
+	}
+
+	for _, bridge := range bridges {
+
+		// Already deleted, possibly by another podconfig sharing it
+		if getBridgeOnHost(bridge) != nil {
+			continue
+		}
+		deleted, err := deleteBridge(bridge)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			event(corev1.EventTypeNormal, reasonBridgeDeleted, "Deleted bridge %s from node", bridge)
+		}
+	}
+	return nil
+}
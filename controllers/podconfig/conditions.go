@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Sets a status condition, the transition time only moves when the
+// status changes. Returns true when anything changed.
+func setCondition(status *podconfigv1alpha1.PodConfigStatus, conditionType podconfigv1alpha1.PodConfigConditionType,
+	conditionStatus corev1.ConditionStatus, reason, message string) bool {
+
+	condition := podconfigv1alpha1.PodConfigCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	for i, c := range status.Conditions {
+		if c.Type != conditionType {
+			continue
+		}
+		if c.Status == conditionStatus {
+			if c.Reason == reason && c.Message == message {
+				return false
+			}
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return true
+	}
+
+	status.Conditions = append(status.Conditions, condition)
+	return true
+}
+
+// Sets the phase and the Drifted condition from the configurations of the
+// pods of every node, so that the operators of all nodes agree on them.
+// Returns true when anything changed.
+func aggregateStatus(status *podconfigv1alpha1.PodConfigStatus, pods []corev1.Pod) bool {
+
+	phase := podconfigv1alpha1.PodConfigConfigured
+	for _, pod := range pods {
+		if !pod.ObjectMeta.DeletionTimestamp.IsZero() || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		configured := false
+		for _, p := range status.PodConfigurations {
+			if p.PodName == pod.ObjectMeta.Name {
+				configured = true
+			}
+		}
+		if !configured {
+			phase = podconfigv1alpha1.PodConfigConfiguring
+		}
+	}
+	changed := status.Phase != phase
+	status.Phase = phase
+
+	drift := []string{}
+	for _, p := range status.PodConfigurations {
+		for _, d := range p.Drift {
+			drift = append(drift, p.PodName+": "+d)
+		}
+	}
+	if len(drift) > 0 {
+		return setCondition(status, podconfigv1alpha1.PodConfigDrifted, corev1.ConditionTrue,
+			"DriftRepaired", strings.Join(drift, "; ")) || changed
+	}
+	return setCondition(status, podconfigv1alpha1.PodConfigDrifted, corev1.ConditionFalse, "InSync", "") || changed
+}
+
+// Forbidden API errors may come wrapped by the helpers building on the client
+func isForbidden(err error) bool {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Reason == metav1.StatusReasonForbidden
+	}
+	return false
+}
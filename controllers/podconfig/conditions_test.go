@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("PodConfig conditions", func() {
+
+	var (
+		status  *podconfigv1alpha1.PodConfigStatus
+		earlier metav1.Time
+	)
+
+	BeforeEach(func() {
+		earlier = metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+		status = &podconfigv1alpha1.PodConfigStatus{
+			Conditions: []podconfigv1alpha1.PodConfigCondition{{
+				Type:               podconfigv1alpha1.PodConfigAdmitted,
+				Status:             corev1.ConditionFalse,
+				Reason:             "PolicyViolation",
+				Message:            "not allowed by podconfig policy cidrs",
+				LastTransitionTime: earlier,
+			}},
+		}
+	})
+
+	It("adds a missing condition", func() {
+		status.Conditions = nil
+
+		Expect(setCondition(status, podconfigv1alpha1.PodConfigAdmitted, corev1.ConditionTrue, "PolicyAllowed", "")).To(BeTrue())
+		Expect(status.Conditions).To(HaveLen(1))
+		Expect(status.Conditions[0].Status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("reports no change for the same condition", func() {
+		Expect(setCondition(status, podconfigv1alpha1.PodConfigAdmitted, corev1.ConditionFalse,
+			"PolicyViolation", "not allowed by podconfig policy cidrs")).To(BeFalse())
+		Expect(status.Conditions[0].LastTransitionTime).To(Equal(earlier))
+	})
+
+	It("keeps the transition time when only the message changes", func() {
+		Expect(setCondition(status, podconfigv1alpha1.PodConfigAdmitted, corev1.ConditionFalse,
+			"PolicyViolation", "not allowed by podconfig policy vlans")).To(BeTrue())
+		Expect(status.Conditions[0].Message).To(Equal("not allowed by podconfig policy vlans"))
+		Expect(status.Conditions[0].LastTransitionTime).To(Equal(earlier))
+	})
+
+	It("moves the transition time when the status changes", func() {
+		Expect(setCondition(status, podconfigv1alpha1.PodConfigAdmitted, corev1.ConditionTrue, "PolicyAllowed", "")).To(BeTrue())
+		Expect(status.Conditions).To(HaveLen(1))
+		Expect(status.Conditions[0].LastTransitionTime.After(earlier.Time)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Forbidden errors", func() {
+
+	pods := schema.GroupResource{Resource: "pods"}
+
+	It("are found through the wrapping helpers", func() {
+		err := apierrors.NewForbidden(pods, "", fmt.Errorf("no rbac"))
+
+		Expect(isForbidden(err)).To(BeTrue())
+		Expect(isForbidden(fmt.Errorf("failed to list pods: %w", err))).To(BeTrue())
+	})
+
+	It("are told apart from other errors", func() {
+		Expect(isForbidden(apierrors.NewNotFound(pods, "cnf"))).To(BeFalse())
+		Expect(isForbidden(fmt.Errorf("empty pod list"))).To(BeFalse())
+	})
+})
+
+var _ = Describe("Aggregated status", func() {
+
+	var status *podconfigv1alpha1.PodConfigStatus
+
+	newRunningPod := func(name string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	BeforeEach(func() {
+		status = &podconfigv1alpha1.PodConfigStatus{
+			PodConfigurations: []podconfigv1alpha1.PodConfiguration{{PodName: "cnf-a"}, {PodName: "cnf-b"}},
+		}
+	})
+
+	It("is configured once every running pod of every node is", func() {
+		pending := newRunningPod("cnf-c")
+		pending.Status.Phase = corev1.PodPending
+
+		Expect(aggregateStatus(status, []corev1.Pod{newRunningPod("cnf-a"), newRunningPod("cnf-b"), pending})).To(BeTrue())
+		Expect(status.Phase).To(Equal(podconfigv1alpha1.PodConfigConfigured))
+		Expect(status.Conditions[0].Reason).To(Equal("InSync"))
+
+		Expect(aggregateStatus(status, []corev1.Pod{newRunningPod("cnf-a"), newRunningPod("cnf-b")})).To(BeFalse())
+	})
+
+	It("is configuring while a running pod isn't configured", func() {
+		deleted := newRunningPod("cnf-d")
+		now := metav1.Now()
+		deleted.DeletionTimestamp = &now
+
+		aggregateStatus(status, []corev1.Pod{newRunningPod("cnf-a"), newRunningPod("cnf-c"), deleted})
+		Expect(status.Phase).To(Equal(podconfigv1alpha1.PodConfigConfiguring))
+	})
+
+	It("reports the drift of any pod in the Drifted condition", func() {
+		status.PodConfigurations[1].Drift = []string{"interface net1 missing", "bridge br0 missing"}
+
+		Expect(aggregateStatus(status, nil)).To(BeTrue())
+		Expect(status.Conditions).To(HaveLen(1))
+		Expect(status.Conditions[0].Type).To(Equal(podconfigv1alpha1.PodConfigDrifted))
+		Expect(status.Conditions[0].Status).To(Equal(corev1.ConditionTrue))
+		Expect(status.Conditions[0].Message).To(Equal("cnf-b: interface net1 missing; cnf-b: bridge br0 missing"))
+
+		status.PodConfigurations[1].Drift = nil
+		Expect(aggregateStatus(status, nil)).To(BeTrue())
+		Expect(status.Conditions[0].Status).To(Equal(corev1.ConditionFalse))
+	})
+})
@@ -1,51 +1,117 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"github.com/vishvananda/netlink"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func applyConfig(pod corev1.Pod, podconfig *podconfigv1alpha1.PodConfig) ([]string, error) {
+// Applies the podconfig to a pod. Links that drifted from the state
+// recorded for the pod are repaired first and the differences returned.
+func applyConfig(pod corev1.Pod, podconfig *podconfigv1alpha1.PodConfig, event eventFunc) (configuration podconfigv1alpha1.PodConfiguration, drift []string, err error) {
 
-	// Get the first container pid for pod
-	pid, err := getPid(pod)
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		applyDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
+	configuration.PodName = pod.ObjectMeta.Name
+	configuration.Namespace = pod.ObjectMeta.Namespace
+	configuration.Node = pod.Spec.NodeName
+
+	// Get the network namespace of the pod
+	netNS, err := lookupNetNS(pod)
+	if isSandboxNotReady(err) {
+		event(corev1.EventTypeNormal, reasonPodSkipped, "Pod sandbox or containers not running yet, waiting for them")
+		return configuration, drift, err
+	}
 	if err != nil {
-		fmt.Printf("Error getting container pid %v", err)
-		return []string{}, err
+		event(corev1.EventTypeWarning, reasonCRILookupFailed, "Error getting pod network namespace: %v", err)
+		return configuration, drift, err
+	}
+
+	owner := attachmentOwner{Namespace: pod.ObjectMeta.Namespace, PodConfig: podconfig.ObjectMeta.Name, Pod: pod.ObjectMeta.Name}
+
+	recordedMounts := []string{}
+	recordedCapabilities := []podconfigv1alpha1.FileCapabilityStatus{}
+	for _, recorded := range podconfig.Status.PodConfigurations {
+		if recorded.PodName == pod.ObjectMeta.Name {
+			drift = repairDrift(netNS, podconfig.Spec.NetworkAttachments, recorded.Interfaces, owner)
+			recordedMounts = recorded.Mounts
+			recordedCapabilities = recorded.FileCapabilities
+		}
+	}
+	if len(drift) > 0 {
+		event(corev1.EventTypeWarning, reasonDriftDetected, "Repairing drifted configuration: %v", strings.Join(drift, ", "))
 	}
 
-	configList, err := createNetworkAttachments(pid, podconfig.Spec.NetworkAttachments)
+	// Every step is recorded so that a pod is never left half configured
+	tx := &transaction{}
+
+	configuration.Interfaces, err = createNetworkAttachments(tx, netNS, podconfig.Spec.NetworkAttachments, podconfig.Spec.Bridges, owner, event)
 	if err != nil {
-		fmt.Printf("Error creating network attachments: %v\n", err)
-		return configList, err
+		event(corev1.EventTypeWarning, reasonConfigFailed, "Error creating network attachments: %v", err)
+		completed := tx.completed()
+		if rollbackErr := tx.rollback(); rollbackErr != nil {
+			return configuration, drift, fmt.Errorf("%v, rollback failed: %v", err, rollbackErr)
+		}
+		if len(completed) > 0 {
+			event(corev1.EventTypeNormal, reasonRolledBack, "Rolled back completed steps: %v", completed)
+		}
+		return configuration, drift, err
 	}
 
-	return configList, nil
-}
+	// Sysctls may refer to the interfaces just created
+	err = applySysctls(netNS, podconfig.Spec.Sysctls, event)
+	if err != nil {
+		event(corev1.EventTypeWarning, reasonConfigFailed, "Error setting sysctls: %v", err)
+		return configuration, drift, err
+	}
 
-func deleteConfig(pod corev1.Pod, podconfig *podconfigv1alpha1.PodConfig) error {
-	// Get the first container pid for pod
-	pid, err := getPid(pod)
+	// Mounts go to their own target containers
+	configuration.Mounts, err = applyMounts(pod, podconfig.Spec.Mounts, recordedMounts, event)
 	if err != nil {
-		fmt.Printf("Error getting container pid %v", err)
-		return err
+		event(corev1.EventTypeWarning, reasonConfigFailed, "Error applying mounts: %v", err)
+		return configuration, drift, err
 	}
 
-	err = deleteNetworkAttachments(pid, podconfig.Spec.NetworkAttachments)
+	// and so do file capabilities
+	configuration.FileCapabilities, err = applyCapabilities(pod, podconfig.Spec.Capabilities, recordedCapabilities, event)
 	if err != nil {
-		fmt.Printf("Error creating network attachments: %v\n", err)
-		return err
+		event(corev1.EventTypeWarning, reasonConfigFailed, "Error applying capabilities: %v", err)
+		return configuration, drift, err
 	}
-	return nil
+
+	for _, iface := range configuration.Interfaces {
+		configuration.ConfigList = append(configuration.ConfigList, fmt.Sprintf("{podVethName:%s podIPAddr:%s peerVethName:%s bridge:%s}",
+			iface.Name, iface.Address, iface.HostName, iface.Bridge))
+	}
+	return configuration, drift, nil
 }
 
-func createNetworkAttachments(pid string, networkAttachments []podconfigv1alpha1.Link) ([]string, error) {
+// Gets the pod network namespace from the runtime, timed as the cri_lookup step
+func lookupNetNS(pod corev1.Pod) (podNetNS, error) {
 
-	configList := []string{}
+	defer observeStep("cri_lookup", time.Now())
+
+	return getPodNetNS(pod)
+}
+
+func createNetworkAttachments(tx *transaction, netNS podNetNS, networkAttachments []podconfigv1alpha1.Link, bridges []podconfigv1alpha1.BridgeSpec, owner attachmentOwner, event eventFunc) ([]podconfigv1alpha1.InterfaceStatus, error) {
+
+	configList := []podconfigv1alpha1.InterfaceStatus{}
 
 	for _, na := range networkAttachments {
+		na := na
 
 		err := getBridgeOnHost(na.Master)
 
@@ -54,17 +120,98 @@ func createNetworkAttachments(pid string, networkAttachments []podconfigv1alpha1
 			fmt.Printf("%v\n", err)
 			fmt.Println("Creating bridge on Host.")
 
+			// Bridge address depends on the attachment gateway mode
+			var gateway *netlink.Addr
+			err = tx.do("allocate gateway for "+na.Master,
+				func() (err error) {
+					gateway, err = gatewayAddress(na)
+					return err
+				},
+				func() error {
+					ips.ReleaseOwner(bridgeOwner(na.Master))
+					return nil
+				})
+			if err != nil {
+				fmt.Printf("Error getting gateway address for bridge %s: %v\n", na.Master, err)
+				return configList, err
+			}
+
 			// Create bridge in host namespace
-			err := createBridge(na.Master, ips.getFreeIP(na.CIDR))
+			err = tx.do("create bridge "+na.Master,
+				func() error {
+					return createBridge(na.Master, gateway, na.Vlan != nil)
+				},
+				func() error {
+					_, err := deleteBridge(na.Master)
+					return err
+				})
 			if err != nil {
 				fmt.Printf("Error creating bridge device %s: %v\n", na.Master, err)
 				return configList, err
 			}
+			event(corev1.EventTypeNormal, reasonBridgeCreated, "Created bridge %s on node", na.Master)
+
+		} else {
 
+			// The address of a bridge created before a restart is not free,
+			// bridges without an address in the CIDR are L2 only
+			gateway, _ := getBridgeGateway(na.Master, na.CIDR)
+			err = ips.reserveGateway(na.CIDR, gateway, bridgeOwner(na.Master))
+			if err != nil {
+				fmt.Printf("Error reserving the address of bridge %s: %v\n", na.Master, err)
+				return configList, err
+			}
+
+			if na.Vlan != nil {
+
+				// Bridge already exists and needs to be vlan aware for this attachment
+				var previous map[uint16][]byte
+				err := tx.do("enable vlan filtering on "+na.Master,
+					func() (err error) {
+						previous, err = enableBridgeVlanFiltering(na.Master)
+						return err
+					},
+					func() error {
+						return restoreBridgeAttrs(na.Master, previous)
+					})
+				if err != nil {
+					fmt.Printf("Error enabling vlan filtering on bridge %s: %v\n", na.Master, err)
+					return configList, err
+				}
+			}
+		}
+
+		// Apply bridge level settings before any port is attached
+		for _, bridgeSpec := range bridges {
+			if bridgeSpec.Name == na.Master {
+				bridgeSpec := bridgeSpec
+				var previous map[uint16][]byte
+				notOwned := false
+				err := tx.do("set options on "+na.Master,
+					func() (err error) {
+						previous, err = setBridgeOptions(bridgeSpec)
+						if errors.Is(err, errBridgeNotOwned) {
+							notOwned = true
+							return nil
+						}
+						return err
+					},
+					func() error {
+						return restoreBridgeAttrs(bridgeSpec.Name, previous)
+					})
+				if err != nil {
+					fmt.Printf("Error setting options on bridge %s: %v\n", na.Master, err)
+					return configList, err
+				}
+				if notOwned {
+					event(corev1.EventTypeWarning, reasonBridgeOptionsSkipped,
+						"Bridge %s was not created by the operator, its options are left unchanged", na.Master)
+				}
+			}
 		}
 
 		// Create veth pairs for the new networkAttachment
-		config, err := createVethForPod(pid, na)
+		config, err := createVethForPod(tx, netNS, na, owner, event)
 		if err != nil {
 			fmt.Printf("Error creating new veth pair for pod: %v\n", err)
 			return configList, err
@@ -75,24 +222,3 @@ func createNetworkAttachments(pid string, networkAttachments []podconfigv1alpha1
 	fmt.Println("New network attachment created successfully.")
 	return configList, nil
 }
-
-func deleteNetworkAttachments(pid string, networkAttachments []podconfigv1alpha1.Link) error {
-
-	for _, na := range networkAttachments {
-
-		// delete veth pair for pod network attachments
-		err := deleteVethForPod(pid, na)
-		if err != nil {
-			fmt.Printf("Error deleting new veth pair for pod: %v\n", err)
-			return err
-		}
-
-		// delete remaining bridge
-		err = deleteBridge(na.Master)
-		if err != nil {
-			fmt.Printf("Error creating bridge device %s: %v\n", na.Master, err)
-			return err
-		}
-	}
-	return nil
-}
@@ -1,18 +1,31 @@
 package controllers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 
 	"github.com/containernetworking/plugins/pkg/ns"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Kernel clock ticks per second used for bridge timers
+	userHZ = 100
+
+	// Bridge port attribute missing from the netlink package
+	iflaBrportIsolated = 33
 )
 
 // Bridge creation logic
-// TODO: verify existence first and only creates if doesn't exist
-// TODO: do the clean up on podconfig deletion - probable use for a finalizer
+// Bridges created here are tagged with the operator alias and deleted
+// only when their last port is gone
 func getBridgeOnHost(bridge string) error {
 
-	targetNS, err := ns.GetNS("/tmp/proc/1/ns/net")
+	targetNS, err := ns.GetNS(hostNetNSPath())
 	if err != nil {
 		return fmt.Errorf("error getting host network namespace: %v", err)
 	}
@@ -33,9 +46,9 @@ func getBridgeOnHost(bridge string) error {
 
 	return nil
 }
-func createBridge(bridge string, ipAddr *netlink.Addr) error {
+func createBridge(bridge string, ipAddr *netlink.Addr, vlanFiltering bool) error {
 
-	targetNS, err := ns.GetNS("/tmp/proc/1/ns/net")
+	targetNS, err := ns.GetNS(hostNetNSPath())
 	if err != nil {
 		return fmt.Errorf("error getting host network namespace: %v", err)
 	}
@@ -46,56 +59,407 @@ func createBridge(bridge string, ipAddr *netlink.Addr) error {
 				Name: bridge,
 			},
 		}
+		if vlanFiltering {
+			br.VlanFiltering = &vlanFiltering
+		}
 		// Creating bridge
 		err := netlink.LinkAdd(br)
 		if err != nil {
 			return fmt.Errorf("failed to create bridge %v: %v", bridge, err)
 		}
 
-		// Setting bridge ip address
-		err = netlink.AddrAdd(br, ipAddr)
+		// Configure the new bridge, it is removed again if any step fails
+		err = configureBridge(br, ipAddr)
 		if err != nil {
-			return fmt.Errorf("failed to set bridge ip address: %v", err)
+			if delErr := netlink.LinkDel(br); delErr != nil {
+				return fmt.Errorf("%v, failed to delete bridge %v: %v", err, bridge, delErr)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Bridge created successfully on the host.")
+	return nil
+}
+
+// Turns on vlan_filtering for an operator bridge that already exists on
+// the host so that attachments with VLANs can share it. Returns the
+// previous value for restoreBridgeAttrs.
+func enableBridgeVlanFiltering(bridge string) (map[uint16][]byte, error) {
+
+	targetNS, err := ns.GetNS(hostNetNSPath())
+	if err != nil {
+		return nil, fmt.Errorf("error getting host network namespace: %v", err)
+	}
+
+	var previous map[uint16][]byte
+
+	err = targetNS.Do(func(hostNs ns.NetNS) error {
+
+		link, err := netlink.LinkByName(bridge)
+		if err != nil {
+			return fmt.Errorf("error looking up for bridge %v %v", bridge, err)
+		}
+
+		br, ok := link.(*netlink.Bridge)
+		if !ok {
+			return fmt.Errorf("link %v is not a bridge", bridge)
 		}
 
-		// Setting bridge up
-		err = netlink.LinkSetUp(br)
+		// Filtering changes how every port of the bridge forwards, bridges
+		// not created by the operator must already be vlan aware
+		if br.VlanFiltering != nil && *br.VlanFiltering {
+			return nil
+		}
+		if !isOwnedBridge(br.Attrs().Alias) {
+			return fmt.Errorf("bridge %v was not created by the operator and has vlan filtering off", bridge)
+		}
+
+		previous, err = changeBridgeAttrs(link, map[uint16][]byte{
+			nl.IFLA_BR_VLAN_FILTERING: {1},
+		})
 		if err != nil {
-			return fmt.Errorf("failed to set bridge up: %v", err)
+			return fmt.Errorf("failed to enable vlan filtering on bridge %v: %v", bridge, err)
 		}
 		return nil
 	})
+
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// errBridgeNotOwned is returned for bridge options of a bridge not created
+// by the operator, its options are left as they are
+var errBridgeNotOwned = errors.New("bridge not created by the operator")
+
+// Applies the bridge level settings from the podconfig spec to a bridge
+// created by the operator on the host. Only the settings present in the
+// spec are changed, their previous values are returned for restoreBridgeAttrs.
+func setBridgeOptions(bridgeSpec podconfigv1alpha1.BridgeSpec) (map[uint16][]byte, error) {
+
+	targetNS, err := ns.GetNS(hostNetNSPath())
+	if err != nil {
+		return nil, fmt.Errorf("error getting host network namespace: %v", err)
+	}
+
+	var previous map[uint16][]byte
+
+	err = targetNS.Do(func(hostNs ns.NetNS) error {
+
+		br, err := netlink.LinkByName(bridgeSpec.Name)
+		if err != nil {
+			return fmt.Errorf("error looking up for bridge %v %v", bridgeSpec.Name, err)
+		}
+
+		// Bridges not created by the operator are left untouched
+		if !isOwnedBridge(br.Attrs().Alias) {
+			return errBridgeNotOwned
+		}
+
+		previous, err = changeBridgeAttrs(br, bridgeOptionAttrs(bridgeSpec))
+		if err != nil {
+			return fmt.Errorf("failed to set options on bridge %v: %v", bridgeSpec.Name, err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// Bridge attributes for the settings present in the spec. Bridge timers
+// are given to the kernel in USER_HZ (centiseconds).
+func bridgeOptionAttrs(bridgeSpec podconfigv1alpha1.BridgeSpec) map[uint16][]byte {
+
+	attrs := map[uint16][]byte{}
+	if bridgeSpec.STP != nil {
+		attrs[nl.IFLA_BR_STP_STATE] = nl.Uint32Attr(uint32(boolToByte(*bridgeSpec.STP)[0]))
+	}
+	if bridgeSpec.ForwardDelay != nil {
+		attrs[nl.IFLA_BR_FORWARD_DELAY] = nl.Uint32Attr(*bridgeSpec.ForwardDelay * userHZ)
+	}
+	if bridgeSpec.AgeingTime != nil {
+		attrs[nl.IFLA_BR_AGEING_TIME] = nl.Uint32Attr(*bridgeSpec.AgeingTime * userHZ)
+	}
+	if bridgeSpec.MulticastSnooping != nil {
+		attrs[nl.IFLA_BR_MCAST_SNOOPING] = boolToByte(*bridgeSpec.MulticastSnooping)
+	}
+	if bridgeSpec.MulticastQuerier != nil {
+		attrs[nl.IFLA_BR_MCAST_QUERIER] = boolToByte(*bridgeSpec.MulticastQuerier)
+	}
+	return attrs
+}
+
+// Puts back bridge attributes changed by enableBridgeVlanFiltering or
+// setBridgeOptions. A bridge deleted meanwhile is left alone.
+func restoreBridgeAttrs(bridge string, previous map[uint16][]byte) error {
+
+	if len(previous) == 0 {
+		return nil
+	}
+
+	return doInNetNS(hostNetNSPath(), func() error {
+
+		br, err := netlink.LinkByName(bridge)
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up for bridge %v %v", bridge, err)
+		}
+
+		err = setBridgeAttrs(br, func(data *nl.RtAttr) {
+			for attrType, value := range previous {
+				data.AddRtAttr(int(attrType), value)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore attributes of bridge %v: %v", bridge, err)
+		}
+		return nil
+	})
+}
+
+// Sets the isolation and hairpin flags of a bridge port. Must be called
+// from the host network namespace after the port has been attached.
+func setPortFlags(port netlink.Link, networkAttachment podconfigv1alpha1.Link) error {
+
+	err := netlink.LinkSetHairpin(port, networkAttachment.Hairpin)
+	if err != nil {
+		return fmt.Errorf("failed to set hairpin mode on %q: %v", port.Attrs().Name, err)
+	}
+
+	// IFLA_BRPORT_ISOLATED is not wrapped by the netlink package
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_BRIDGE)
+	msg.Index = int32(port.Attrs().Index)
+	req.AddData(msg)
+
+	protinfo := nl.NewRtAttr(unix.IFLA_PROTINFO|unix.NLA_F_NESTED, nil)
+	protinfo.AddRtAttr(iflaBrportIsolated, boolToByte(networkAttachment.Isolated))
+	req.AddData(protinfo)
+
+	_, err = req.Execute(unix.NETLINK_ROUTE, 0)
+	if err != nil {
+		return fmt.Errorf("failed to set isolation on %q: %v", port.Attrs().Name, err)
 	}
-	fmt.Println("Bridge created successfully on the host.")
 	return nil
 }
 
-func deleteBridge(bridge string) error {
+func boolToByte(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
 
-	targetNS, err := ns.GetNS("/tmp/proc/1/ns/net")
+// Counts the links attached to a bridge. Must be called from the bridge
+// network namespace.
+func countBridgePorts(br netlink.Link) (int, error) {
+
+	links, err := netlink.LinkList()
 	if err != nil {
-		return fmt.Errorf("error getting host network namespace: %v", err)
+		return 0, fmt.Errorf("failed to list links: %v", err)
+	}
+
+	ports := 0
+	for _, link := range links {
+		if link.Attrs().MasterIndex == br.Attrs().Index {
+			ports++
+		}
+	}
+	return ports, nil
+}
+
+// Changes IFLA_INFO_DATA attributes of an existing bridge. The netlink
+// package only sets them on creation, so the request is built here.
+// Must be called from the bridge network namespace.
+func setBridgeAttrs(br netlink.Link, addAttrs func(data *nl.RtAttr)) error {
+
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(br.Attrs().Index)
+	req.AddData(msg)
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("bridge"))
+	addAttrs(linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// Reads the IFLA_INFO_DATA attributes of a bridge, by attribute type.
+// Must be called from the bridge network namespace.
+func getBridgeAttrs(br netlink.Link) (map[uint16][]byte, error) {
+
+	req := nl.NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(br.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no link found with index %d", br.Attrs().Index)
+	}
+
+	attrs, err := nl.ParseRouteAttr(msgs[0][unix.SizeofIfInfomsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[uint16][]byte{}
+	for _, attr := range attrs {
+		if attr.Attr.Type != unix.IFLA_LINKINFO {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.Attr.Type != nl.IFLA_INFO_DATA {
+				continue
+			}
+			datums, err := nl.ParseRouteAttr(info.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, datum := range datums {
+				data[datum.Attr.Type] = datum.Value
+			}
+		}
+	}
+	return data, nil
+}
+
+// Sets the bridge attributes that differ from the wanted values and returns
+// the values they had. Must be called from the bridge network namespace.
+func changeBridgeAttrs(br netlink.Link, wanted map[uint16][]byte) (map[uint16][]byte, error) {
+
+	current, err := getBridgeAttrs(br)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[uint16][]byte{}
+	changed := map[uint16][]byte{}
+	for attrType, value := range wanted {
+		old, found := current[attrType]
+		if found && bytes.Equal(old, value) {
+			continue
+		}
+		if found {
+			previous[attrType] = old
+		}
+		changed[attrType] = value
+	}
+	if len(changed) == 0 {
+		return previous, nil
+	}
+
+	err = setBridgeAttrs(br, func(data *nl.RtAttr) {
+		for attrType, value := range changed {
+			data.AddRtAttr(int(attrType), value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+func configureBridge(br *netlink.Bridge, ipAddr *netlink.Addr) error {
+
+	// Tagging bridge as created by the operator
+	err := netlink.LinkSetAlias(br, ownerAlias)
+	if err != nil {
+		return fmt.Errorf("failed to set bridge alias: %v", err)
+	}
+
+	// Setting bridge ip address, L2 only bridges have none
+	if ipAddr != nil {
+		err = netlink.AddrAdd(br, ipAddr)
+		if err != nil {
+			return fmt.Errorf("failed to set bridge ip address: %v", err)
+		}
+	}
+
+	// Setting bridge up
+	err = netlink.LinkSetUp(br)
+	if err != nil {
+		return fmt.Errorf("failed to set bridge up: %v", err)
+	}
+	return nil
+}
+
+// Deletes a bridge created by the operator once it has no ports left.
+// Returns true when the bridge was deleted, a missing bridge is not an error.
+func deleteBridge(bridge string) (bool, error) {
+
+	targetNS, err := ns.GetNS(hostNetNSPath())
+	if err != nil {
+		return false, fmt.Errorf("error getting host network namespace: %v", err)
 	}
 
+	deleted := false
+
 	err = targetNS.Do(func(hostNs ns.NetNS) error {
 
 		br, err := netlink.LinkByName(bridge)
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return nil
+		}
 		if err != nil {
 			return fmt.Errorf("error looking up for bridge %v %v", bridge, err)
 		}
 
+		// Bridges not created by the operator are left untouched
+		if !isOwnedBridge(br.Attrs().Alias) {
+			fmt.Printf("Bridge %v was not created by the operator. Skipping deletion ...\n", bridge)
+			return nil
+		}
+
+		// Every port still attached is a user of the bridge
+		ports, err := countBridgePorts(br)
+		if err != nil {
+			return err
+		}
+		if ports > 0 {
+			fmt.Printf("Bridge %v still has %d ports attached. Skipping deletion ...\n", bridge, ports)
+			return nil
+		}
+
 		err = netlink.LinkDel(br)
 		if err != nil {
 			return fmt.Errorf("failed to delete bridge %q: %v", bridge, err)
 		}
+
+		// Gateway address goes back to the pool with the bridge
+		ips.ReleaseOwner(bridgeOwner(bridge))
+		deleted = true
 		return nil
 	})
 
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		return false, err
 	}
 
-	return nil
+	return deleted, nil
 }
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"github.com/vishvananda/netlink"
+)
+
+// Returns the address to be set on a new master bridge according to the
+// attachment gateway mode. A nil address leaves the bridge L2 only.
+func gatewayAddress(networkAttachment podconfigv1alpha1.Link) (*netlink.Addr, error) {
+
+	if networkAttachment.Gateway == nil {
+		return freeGatewayAddress(networkAttachment)
+	}
+
+	switch networkAttachment.Gateway.Mode {
+	case podconfigv1alpha1.GatewayNone:
+		return nil, nil
+	case podconfigv1alpha1.GatewayStatic:
+		return ips.getStaticIP(networkAttachment.CIDR, networkAttachment.Gateway.Address, bridgeOwner(networkAttachment.Master))
+	case podconfigv1alpha1.GatewayAuto:
+		return freeGatewayAddress(networkAttachment)
+	}
+	return nil, fmt.Errorf("unknown gateway mode %q", networkAttachment.Gateway.Mode)
+}
+
+// Takes the next free address of the attachment CIDR for the bridge
+func freeGatewayAddress(networkAttachment podconfigv1alpha1.Link) (*netlink.Addr, error) {
+
+	addr := ips.getFreeIP(networkAttachment.CIDR, bridgeOwner(networkAttachment.Master))
+	if addr == nil {
+		return nil, fmt.Errorf("no free ip left in %v for bridge %v", networkAttachment.CIDR, networkAttachment.Master)
+	}
+	return addr, nil
+}
+
+// Returns true when the master bridge of the attachment gets an address
+func hasBridgeGateway(networkAttachment podconfigv1alpha1.Link) bool {
+
+	return networkAttachment.Gateway == nil ||
+		networkAttachment.Gateway.Mode != podconfigv1alpha1.GatewayNone
+}
+
+// Returns true when routes through the bridge gateway must be added to the pod
+func hasGatewayRoutes(networkAttachment podconfigv1alpha1.Link) bool {
+
+	return networkAttachment.Gateway != nil &&
+		networkAttachment.Gateway.Mode != podconfigv1alpha1.GatewayNone &&
+		len(networkAttachment.Gateway.Routes) > 0
+}
+
+// Looks up the bridge address that belongs to the attachment CIDR
+func getBridgeGateway(bridge string, cidr string) (net.IP, error) {
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %q: %v", cidr, err)
+	}
+
+	targetNS, err := ns.GetNS(hostNetNSPath())
+	if err != nil {
+		return nil, fmt.Errorf("error getting host network namespace: %v", err)
+	}
+
+	var gateway net.IP
+
+	err = targetNS.Do(func(hostNs ns.NetNS) error {
+
+		br, err := netlink.LinkByName(bridge)
+		if err != nil {
+			return fmt.Errorf("error looking up for bridge %v %v", bridge, err)
+		}
+
+		addrs, err := netlink.AddrList(br, netlink.FAMILY_V4)
+		if err != nil {
+			return fmt.Errorf("failed to list addresses of bridge %v: %v", bridge, err)
+		}
+
+		for _, addr := range addrs {
+			if ipNet.Contains(addr.IP) {
+				gateway = addr.IP
+				return nil
+			}
+		}
+		return fmt.Errorf("bridge %v has no gateway address in %v", bridge, cidr)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gateway, nil
+}
+
+// Adds routes through the gateway to a pod interface. Must be called
+// from the pod network namespace. Routes the pod already has are not
+// replaced, a destination already routed fails.
+func addGatewayRoutes(podVeth netlink.Link, gateway net.IP, routes []string) error {
+
+	for _, route := range routes {
+
+		_, dst, err := net.ParseCIDR(route)
+		if err != nil {
+			return fmt.Errorf("invalid route destination %q: %v", route, err)
+		}
+
+		err = netlink.RouteAdd(&netlink.Route{
+			LinkIndex: podVeth.Attrs().Index,
+			Dst:       dst,
+			Gw:        gateway,
+		})
+		if err == syscall.EEXIST {
+			return fmt.Errorf("the pod already has a route to %v", dst)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add route to %v via %v: %v", dst, gateway, err)
+		}
+	}
+	return nil
+}
+
+// Deletes the routes through the gateway from a pod interface. Must be
+// called from the pod network namespace.
+func deleteGatewayRoutes(podVethName string, gateway net.IP, routes []string) error {
+
+	podVeth, err := netlink.LinkByName(podVethName)
+	if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", podVethName, err)
+	}
+
+	for _, route := range routes {
+
+		_, dst, err := net.ParseCIDR(route)
+		if err != nil {
+			continue
+		}
+
+		err = netlink.RouteDel(&netlink.Route{
+			LinkIndex: podVeth.Attrs().Index,
+			Dst:       dst,
+			Gw:        gateway,
+		})
+		if err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to delete route to %v via %v: %v", dst, gateway, err)
+		}
+	}
+	return nil
+}
@@ -2,35 +2,44 @@ package controllers
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
 	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
 )
 
-func createVethForPod(pid string, networkAttachment podconfigv1alpha1.Link) (string, error) {
+func createVethForPod(tx *transaction, netNS podNetNS, networkAttachment podconfigv1alpha1.Link, owner attachmentOwner, event eventFunc) (podconfigv1alpha1.InterfaceStatus, error) {
 
-	type vethPodConfig struct {
-		podVethName  string
-		podIPAddr    string
-		peerVethName string
-		bridge       string
-	}
-
-	var vethConfig = vethPodConfig{}
+	var vethConfig = podconfigv1alpha1.InterfaceStatus{Attachment: networkAttachment.Name}
 
 	// Get the pods namespace object
-	targetNS, err := ns.GetNS("/tmp/proc/" + pid + "/ns/net")
+	podNSPath := netNS.path
+	targetNS, err := getNetNS(podNSPath)
 
 	if err != nil {
-		return "", fmt.Errorf("Error getting Pod network namespace: %v", err)
+		return vethConfig, fmt.Errorf("Error getting Pod network namespace: %v", err)
 	}
 
-	// Appending the process id number to the names to identify the links
-	// with the container processes
+	// Appending the pod suffix to the names to identify the links
+	// with the pod
+
+	podVethName := networkAttachment.Name + netNS.linkSuffix
+	hostVethName := "h" + networkAttachment.Name + netNS.linkSuffix
+	portAlias := owner.portAlias(networkAttachment.Name)
 
-	podVethName := networkAttachment.Name + pid
-	hostVethName := "h" + networkAttachment.Name + pid
+	// Events are only sent for interfaces created on this run
+	created := false
+
+	// Routes in the pod go through the address of the master bridge
+	var gateway net.IP
+	if hasGatewayRoutes(networkAttachment) {
+		gateway, err = getBridgeGateway(networkAttachment.Master, networkAttachment.CIDR)
+		if err != nil {
+			return vethConfig, err
+		}
+	}
 
 	// The Do function takes care of all side effects of switching namespaces
 	// and spawning new threads or child processes on the destination namespaces
@@ -41,9 +50,14 @@ func createVethForPod(pid string, networkAttachment podconfigv1alpha1.Link) (str
 
 		// Attempt to check the existence of the pod veth
 		// If if already exists it skips creation and configuration
-		_, err := netlink.LinkByName(podVethName)
+		podVeth, err := netlink.LinkByName(podVethName)
 		if err == nil {
 			fmt.Printf("Veth link %s already exists on the Pod. Skipping creation ...", podVethName)
+			// Record the address found on the existing link
+			addrs, err := netlink.AddrList(podVeth, netlink.FAMILY_V4)
+			if err == nil && len(addrs) > 0 {
+				vethConfig.Address = addrs[0].IPNet.String()
+			}
 			return nil
 		}
 
@@ -53,51 +67,107 @@ func createVethForPod(pid string, networkAttachment podconfigv1alpha1.Link) (str
 			},
 			PeerName: hostVethName,
 		}
-		err = netlink.LinkAdd(veth)
+
+		// Deleting the pod veth also deletes its peer, wherever it has been moved,
+		// along with the addresses, routes and port settings of both ends
+		err = tx.do("create veth "+podVethName,
+			func() error {
+				return netlink.LinkAdd(veth)
+			},
+			func() error {
+				return doInNetNS(podNSPath, func() error {
+					return deleteLinkByName(podVethName)
+				})
+			})
 		if err != nil {
-			return fmt.Errorf("failed to set %q up: %w", podVethName, err)
+			return err
 		}
+		created = true
 
 		// Get newly created pod link by name
-		podVeth, err := netlink.LinkByName(podVethName)
+		podVeth, err = netlink.LinkByName(podVethName)
 
 		if err != nil {
 			return fmt.Errorf("failed to lookup %q: %v", podVethName, err)
 		}
 
 		// Add ip address to pod veth
-		addr := ips.getFreeIP(networkAttachment.CIDR)
-		err = netlink.AddrAdd(podVeth, addr)
+		var addr *netlink.Addr
+		err = tx.do("allocate ip for "+podVethName,
+			func() error {
+				addr = ips.getFreeIP(networkAttachment.CIDR, portAlias)
+				if addr == nil {
+					return fmt.Errorf("no free ip left in %v", networkAttachment.CIDR)
+				}
+				return nil
+			},
+			func() error {
+				ips.ReleaseOwner(portAlias)
+				return nil
+			})
 		if err != nil {
-			return fmt.Errorf("failed to add IP addr to %q: %v", podVeth, err)
+			return err
 		}
-		vethConfig.podIPAddr = fmt.Sprintf("%v", addr)
+
+		err = tx.do(fmt.Sprintf("add ip %v to %v", addr, podVethName), func() error {
+			return netlink.AddrAdd(podVeth, addr)
+		}, nil)
+		if err != nil {
+			return err
+		}
+		vethConfig.Address = addr.IPNet.String()
 
 		// Set pod veth link up
-		err = netlink.LinkSetUp(podVeth)
+		err = tx.do("set "+podVethName+" up", func() error {
+			return netlink.LinkSetUp(podVeth)
+		}, nil)
 		if err != nil {
-			return fmt.Errorf("failed to set %q up: %w", podVethName, err)
+			return err
+		}
+
+		// Add routes through the bridge gateway
+		if gateway != nil {
+			routes := networkAttachment.Gateway.Routes
+			err = tx.do("add gateway routes to "+podVethName,
+				func() error {
+					return addGatewayRoutes(podVeth, gateway, routes)
+				},
+				func() error {
+					return doInNetNS(podNSPath, func() error {
+						return deleteGatewayRoutes(podVethName, gateway, routes)
+					})
+				})
+			if err != nil {
+				return err
+			}
 		}
 
 		// Move host end of the link to the host and continue
 		// the configuration from the host network namespace
 
-		targetNS, err := ns.GetNS("/tmp/proc/1/ns/net")
-
-		hostVeth, _ := netlink.LinkByName(hostVethName)
+		targetNS, err := getNetNS(hostNetNSPath())
+		if err != nil {
+			return fmt.Errorf("error getting host network namespace: %v", err)
+		}
+		defer targetNS.Close()
 
-		err = netlink.LinkSetNsFd(hostVeth, int(targetNS.Fd()))
+		hostVeth, err := netlink.LinkByName(hostVethName)
 		if err != nil {
-			return fmt.Errorf("failed to move veth to host netns: %v", err)
+			return fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
 		}
-		// }
-		return nil
+
+		return tx.do("move "+hostVethName+" to host", func() error {
+			return netlink.LinkSetNsFd(hostVeth, int(targetNS.Fd()))
+		}, nil)
 	})
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		return vethConfig, err
 	}
 
-	targetNS, err = ns.GetNS("/tmp/proc/1/ns/net")
+	targetNS, err = getNetNS(hostNetNSPath())
+	if err != nil {
+		return vethConfig, fmt.Errorf("error getting host network namespace: %v", err)
+	}
 
 	err = targetNS.Do(func(hostNs ns.NetNS) error {
 
@@ -107,75 +177,111 @@ func createVethForPod(pid string, networkAttachment podconfigv1alpha1.Link) (str
 			return fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
 		}
 
+		// Tag host veth with the attachment it belongs to
+		if hostVeth.Attrs().Alias != portAlias {
+			err = tx.do("set alias on "+hostVethName, func() error {
+				return netlink.LinkSetAlias(hostVeth, portAlias)
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
+
 		if hostVeth.Attrs().OperState != netlink.OperUp {
 			// Set host veth link up ( for PoC purposes it's only layer 2 on bridge)
-			if err = netlink.LinkSetUp(hostVeth); err != nil {
-				return fmt.Errorf("failed to set %q up: %w", hostVethName, err)
+			err = tx.do("set "+hostVethName+" up", func() error {
+				return netlink.LinkSetUp(hostVeth)
+			}, nil)
+			if err != nil {
+				return err
 			}
 		}
 
 		// Set host veth link master bridge
 		br, err := netlink.LinkByName(networkAttachment.Master)
+		if err != nil {
+			return fmt.Errorf("error looking up for bridge %v %v", networkAttachment.Master, err)
+		}
 
 		if hostVeth.Attrs().MasterIndex != br.Attrs().Index {
+			err = tx.do("attach "+hostVethName+" to "+networkAttachment.Master, func() error {
+				return netlink.LinkSetMaster(hostVeth, br)
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
 
-			err = netlink.LinkSetMaster(hostVeth, br)
+		// Set access and trunk vlans on the bridge port
+		err = tx.do("set vlans on "+hostVethName, func() error {
+			return setPortVlans(hostVeth, networkAttachment.Vlan)
+		}, nil)
+		if err != nil {
+			return err
+		}
+
+		// The bridge gateway is reached untagged from the access VLAN
+		if networkAttachment.Vlan != nil && networkAttachment.Vlan.Access != 0 && hasBridgeGateway(networkAttachment) {
+			vid := networkAttachment.Vlan.Access
+			added := false
+			err = tx.do(fmt.Sprintf("add vlan %d to %v", vid, networkAttachment.Master),
+				func() (err error) {
+					added, err = addBridgeSelfVlan(br, vid)
+					return err
+				},
+				func() error {
+					if !added {
+						return nil
+					}
+					return deleteBridgeSelfVlan(networkAttachment.Master, vid)
+				})
 			if err != nil {
-				return fmt.Errorf("Error setting master device to %s: %v", hostVethName, err)
+				return err
 			}
 		}
-		return nil
+
+		// Set isolation and hairpin flags on the bridge port
+		return tx.do("set port flags on "+hostVethName, func() error {
+			return setPortFlags(hostVeth, networkAttachment)
+		}, nil)
 	})
 
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		return vethConfig, err
 	}
 
 	// Setup config information for pod
-	vethConfig.podVethName = podVethName
-	vethConfig.bridge = networkAttachment.Master
-	vethConfig.peerVethName = hostVethName
+	vethConfig.Name = podVethName
+	vethConfig.Bridge = networkAttachment.Master
+	vethConfig.HostName = hostVethName
+
+	// Pod side statistics are read from the pod network namespace
+	interfaces.add(portAlias, netNS, podVethName)
 
-	config := fmt.Sprintf("%+v", vethConfig)
+	if created {
+		event(corev1.EventTypeNormal, reasonInterfaceCreated, "Created interface %s attached to bridge %s", podVethName, networkAttachment.Master)
+		event(corev1.EventTypeNormal, reasonAddressAssigned, "Assigned address %s to interface %s", vethConfig.Address, podVethName)
+	}
 
 	fmt.Println("Veth pair created successfully")
-	return config, nil
+	return vethConfig, nil
 }
 
-func deleteVethForPod(pid string, networkAttachment podconfigv1alpha1.Link) error {
-
-	targetNS, err := ns.GetNS("/tmp/proc/" + pid + "/ns/net")
+// Deletes a link from the current network namespace. A link that
+// is already gone is not an error.
+func deleteLinkByName(name string) error {
 
+	link, err := netlink.LinkByName(name)
+	if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("Error getting Pod network namespace: %v", err)
+		return fmt.Errorf("failed to lookup %q: %v", name, err)
 	}
 
-	// Appending the process id number to the names to identify the links
-	// with the container processes
-
-	podVethName := networkAttachment.Name + pid
-
-	// The Do function takes care of all side effects of switching namespaces
-	// and spawning new threads or child processes on the destination namespaces
-	// Since targetNS belongs to pod all instructions enclosed by Do() will be run
-	// on the pods namespace
-
-	err = targetNS.Do(func(hostNs ns.NetNS) error {
-		// Get newly created pod link by name
-		podVeth, err := netlink.LinkByName(podVethName)
-		if err != nil {
-			return fmt.Errorf("failed to lookup %q: %v", podVethName, err)
-		}
-
-		err = netlink.LinkDel(podVeth)
-		if err != nil {
-			return fmt.Errorf("failed to delete link %q: %v", podVethName, err)
-		}
-		return nil
-	})
+	err = netlink.LinkDel(link)
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		return fmt.Errorf("failed to delete link %q: %v", name, err)
 	}
-
 	return nil
 }
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"fmt"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"github.com/vishvananda/netlink"
+)
+
+// defaultBridgeVlan is the PVID the kernel gives every new port on a
+// vlan filtering bridge
+const defaultBridgeVlan = 1
+
+// Sets the VLAN membership of a bridge port. Must be called from the
+// host network namespace after the port has been attached to its master.
+// The default VLAN is removed first so that ports of different tenants
+// only meet on the VLANs they have been given.
+func setPortVlans(port netlink.Link, vlan *podconfigv1alpha1.PortVlan) error {
+
+	if vlan == nil {
+		return nil
+	}
+
+	vlanList, err := netlink.BridgeVlanList()
+	if err != nil {
+		return fmt.Errorf("failed to list bridge vlans: %v", err)
+	}
+
+	for _, info := range vlanList[int32(port.Attrs().Index)] {
+		if info.Vid == defaultBridgeVlan {
+			err = netlink.BridgeVlanDel(port, defaultBridgeVlan, info.PortVID(), info.EngressUntag(), false, true)
+			if err != nil {
+				return fmt.Errorf("failed to remove default vlan from %q: %v", port.Attrs().Name, err)
+			}
+		}
+	}
+
+	// Access VLAN is used both as PVID and egress untagged
+	if vlan.Access != 0 {
+		err = netlink.BridgeVlanAdd(port, uint16(vlan.Access), true, true, false, true)
+		if err != nil {
+			return fmt.Errorf("failed to add access vlan %d to %q: %v", vlan.Access, port.Attrs().Name, err)
+		}
+	}
+
+	// Trunk VLANs are carried tagged
+	for _, vid := range vlan.Trunk {
+		err = netlink.BridgeVlanAdd(port, uint16(vid), false, false, false, true)
+		if err != nil {
+			return fmt.Errorf("failed to add trunk vlan %d to %q: %v", vid, port.Attrs().Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Makes an operator bridge an untagged member of a VLAN, as its PVID, so
+// that the gateway address on the bridge is reachable from ports with that
+// access VLAN. Must be called from the host network namespace. Returns false
+// when nothing was changed.
+func addBridgeSelfVlan(br netlink.Link, vid int16) (bool, error) {
+
+	if !isOwnedBridge(br.Attrs().Alias) {
+		return false, nil
+	}
+
+	vlanList, err := netlink.BridgeVlanList()
+	if err != nil {
+		return false, fmt.Errorf("failed to list bridge vlans: %v", err)
+	}
+	for _, info := range vlanList[int32(br.Attrs().Index)] {
+		if info.Vid == uint16(vid) {
+			return false, nil
+		}
+	}
+
+	err = netlink.BridgeVlanAdd(br, uint16(vid), true, true, true, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to add vlan %d to bridge %q: %v", vid, br.Attrs().Name, err)
+	}
+	return true, nil
+}
+
+// Removes a VLAN added by addBridgeSelfVlan. A bridge deleted meanwhile
+// is left alone.
+func deleteBridgeSelfVlan(bridge string, vid int16) error {
+
+	return doInNetNS(hostNetNSPath(), func() error {
+
+		br, err := netlink.LinkByName(bridge)
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up for bridge %v %v", bridge, err)
+		}
+
+		err = netlink.BridgeVlanDel(br, uint16(vid), true, true, true, false)
+		if err != nil {
+			return fmt.Errorf("failed to remove vlan %d from bridge %q: %v", vid, bridge, err)
+		}
+		return nil
+	})
+}
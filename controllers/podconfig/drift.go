@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"github.com/vishvananda/netlink"
+)
+
+// Compares the links of the pod attachments with the recorded interfaces.
+// Differences that configuring the attachments again doesn't fix, such as
+// a removed address or route or a link set down, are repaired here. Missing links
+// and ports detached from their bridge are left to createNetworkAttachments.
+func repairDrift(netNS podNetNS, networkAttachments []podconfigv1alpha1.Link, recorded []podconfigv1alpha1.InterfaceStatus, owner attachmentOwner) []string {
+
+	drift := []string{}
+
+	for _, na := range networkAttachments {
+
+		var iface *podconfigv1alpha1.InterfaceStatus
+		for i := range recorded {
+			if recorded[i].Attachment == na.Name && recorded[i].Name == na.Name+netNS.linkSuffix {
+				iface = &recorded[i]
+			}
+		}
+		// Nothing recorded for this attachment and pod yet
+		if iface == nil {
+			continue
+		}
+
+		err := doInNetNS(hostNetNSPath(), func() error {
+			drift = append(drift, repairHostDrift(*iface)...)
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error checking host links of %v: %v\n", iface.Name, err)
+		}
+
+		// Routes can only be checked while the bridge gateway is there
+		var gateway net.IP
+		if hasGatewayRoutes(na) {
+			gateway, _ = getBridgeGateway(na.Master, na.CIDR)
+		}
+
+		err = doInNetNS(netNS.path, func() error {
+			podDrift := repairPodDrift(*iface, na, gateway)
+			// A new veth is created for the attachment with a new address
+			if len(podDrift) > 0 && podDrift[0] == fmt.Sprintf("interface %s missing", iface.Name) {
+				ips.ReleaseOwner(owner.portAlias(na.Name))
+			}
+			drift = append(drift, podDrift...)
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error checking pod links of %v: %v\n", iface.Name, err)
+		}
+	}
+	return drift
+}
+
+// Runs in the host network namespace
+func repairHostDrift(iface podconfigv1alpha1.InterfaceStatus) []string {
+
+	drift := []string{}
+
+	br, err := netlink.LinkByName(iface.Bridge)
+	if err != nil {
+		return append(drift, fmt.Sprintf("bridge %s missing", iface.Bridge))
+	}
+	if br.Attrs().Flags&net.FlagUp == 0 {
+		drift = append(drift, fmt.Sprintf("bridge %s down", iface.Bridge))
+		if err := netlink.LinkSetUp(br); err != nil {
+			fmt.Printf("Error setting bridge %v up: %v\n", iface.Bridge, err)
+		}
+	}
+
+	hostVeth, err := netlink.LinkByName(iface.HostName)
+	if err != nil {
+		// Goes away with its pod side peer, reported there
+		return drift
+	}
+	if hostVeth.Attrs().Flags&net.FlagUp == 0 {
+		drift = append(drift, fmt.Sprintf("interface %s down", iface.HostName))
+		if err := netlink.LinkSetUp(hostVeth); err != nil {
+			fmt.Printf("Error setting %v up: %v\n", iface.HostName, err)
+		}
+	}
+	if hostVeth.Attrs().MasterIndex != br.Attrs().Index {
+		drift = append(drift, fmt.Sprintf("interface %s detached from %s", iface.HostName, iface.Bridge))
+	}
+	return drift
+}
+
+// Runs in the pod network namespace
+func repairPodDrift(iface podconfigv1alpha1.InterfaceStatus, networkAttachment podconfigv1alpha1.Link, gateway net.IP) []string {
+
+	drift := []string{}
+
+	podVeth, err := netlink.LinkByName(iface.Name)
+	if err != nil {
+		return append(drift, fmt.Sprintf("interface %s missing", iface.Name))
+	}
+
+	if podVeth.Attrs().Flags&net.FlagUp == 0 {
+		drift = append(drift, fmt.Sprintf("interface %s down", iface.Name))
+		if err := netlink.LinkSetUp(podVeth); err != nil {
+			fmt.Printf("Error setting %v up: %v\n", iface.Name, err)
+		}
+	}
+
+	drift = append(drift, repairAddressDrift(podVeth, iface)...)
+
+	if gateway != nil {
+		drift = append(drift, repairRouteDrift(podVeth, gateway, networkAttachment.Gateway.Routes)...)
+	}
+	return drift
+}
+
+func repairAddressDrift(podVeth netlink.Link, iface podconfigv1alpha1.InterfaceStatus) []string {
+
+	if iface.Address == "" {
+		return nil
+	}
+	addr, err := netlink.ParseAddr(iface.Address)
+	if err != nil {
+		return nil
+	}
+	addrs, err := netlink.AddrList(podVeth, netlink.FAMILY_V4)
+	if err != nil {
+		fmt.Printf("Error listing addresses of %v: %v\n", iface.Name, err)
+		return nil
+	}
+	for _, a := range addrs {
+		if a.Equal(*addr) {
+			return nil
+		}
+	}
+	if err := netlink.AddrAdd(podVeth, addr); err != nil {
+		fmt.Printf("Error adding address %v to %v: %v\n", iface.Address, iface.Name, err)
+	}
+	return []string{fmt.Sprintf("address %s missing on %s", iface.Address, iface.Name)}
+}
+
+func repairRouteDrift(podVeth netlink.Link, gateway net.IP, routes []string) []string {
+
+	found, err := netlink.RouteList(podVeth, netlink.FAMILY_V4)
+	if err != nil {
+		fmt.Printf("Error listing routes of %v: %v\n", podVeth.Attrs().Name, err)
+		return nil
+	}
+
+	drift := []string{}
+	missing := []string{}
+	for _, route := range routes {
+		_, dst, err := net.ParseCIDR(route)
+		if err != nil {
+			continue
+		}
+		present := false
+		for _, r := range found {
+			if r.Dst != nil && r.Dst.String() == dst.String() && r.Gw.Equal(gateway) {
+				present = true
+			}
+		}
+		if !present {
+			missing = append(missing, route)
+			drift = append(drift, fmt.Sprintf("route to %s missing on %s", route, podVeth.Attrs().Name))
+		}
+	}
+	if len(missing) > 0 {
+		if err := addGatewayRoutes(podVeth, gateway, missing); err != nil {
+			fmt.Printf("Error adding routes to %v: %v\n", podVeth.Attrs().Name, err)
+		}
+	}
+	return drift
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event reasons for the configuration of pods
+const (
+	reasonBridgeCreated    = "BridgeCreated"
+	reasonBridgeDeleted    = "BridgeDeleted"
+	reasonInterfaceCreated = "InterfaceCreated"
+	reasonInterfaceDeleted = "InterfaceDeleted"
+	reasonAddressAssigned  = "AddressAssigned"
+	reasonPodSkipped       = "PodSkipped"
+	reasonConfigFailed     = "ConfigurationFailed"
+	reasonCRILookupFailed  = "CRILookupFailed"
+	reasonRolledBack       = "RolledBack"
+	reasonDriftDetected    = "DriftDetected"
+	reasonTeardownFailed   = "TeardownFailed"
+	reasonBindMounted      = "BindMounted"
+	reasonUnmounted        = "Unmounted"
+	reasonTeardownTimedOut = "TeardownTimedOut"
+	reasonPolicyViolation  = "PolicyViolation"
+	reasonQuotaExceeded    = "QuotaExceeded"
+	reasonSysctlSet        = "SysctlSet"
+
+	reasonCapabilitiesSet      = "CapabilitiesSet"
+	reasonCapabilitiesReverted = "CapabilitiesReverted"
+	reasonRevertFailed         = "RevertFailed"
+	reasonBridgeOptionsSkipped = "BridgeOptionsSkipped"
+)
+
+// Event reasons for pod commands
+const (
+	reasonCommandStarted   = "CommandStarted"
+	reasonCommandSucceeded = "CommandSucceeded"
+	reasonCommandFailed    = "CommandFailed"
+)
+
+// Event reasons for packet captures
+const (
+	reasonCaptureStarted   = "CaptureStarted"
+	reasonCaptureCompleted = "CaptureCompleted"
+	reasonCaptureFailed    = "CaptureFailed"
+)
+
+// eventFunc reports a configuration action as it happens
+type eventFunc func(eventtype, reason, messageFmt string, args ...interface{})
+
+// Returns an eventFunc recording every event on the podconfig and, when
+// given, on the pod so tenants can follow it with kubectl describe.
+// Warnings are also counted as failures by reason.
+func podEvents(recorder record.EventRecorder, podConfig *podconfigv1alpha1.PodConfig, pod *corev1.Pod) eventFunc {
+	return func(eventtype, reason, messageFmt string, args ...interface{}) {
+		message := fmt.Sprintf(messageFmt, args...)
+		fmt.Println(message)
+		if eventtype == corev1.EventTypeWarning {
+			failures.WithLabelValues(reason).Inc()
+		}
+		if recorder == nil {
+			return
+		}
+		if pod != nil {
+			recorder.Event(podConfig, eventtype, reason, fmt.Sprintf("Pod %s: %s", pod.ObjectMeta.Name, message))
+			recorder.Event(pod, eventtype, reason, message)
+			return
+		}
+		recorder.Event(podConfig, eventtype, reason, message)
+	}
+}
+
+// Returns an eventFunc recording every event on a request made for a pod,
+// such as a pod command, and when given on the pod itself
+func requestEvents(recorder record.EventRecorder, request runtime.Object, kind string, name string, pod *corev1.Pod) eventFunc {
+	return func(eventtype, reason, messageFmt string, args ...interface{}) {
+		message := fmt.Sprintf(messageFmt, args...)
+		fmt.Println(message)
+		if eventtype == corev1.EventTypeWarning {
+			failures.WithLabelValues(reason).Inc()
+		}
+		if recorder == nil {
+			return
+		}
+		recorder.Event(request, eventtype, reason, message)
+		if pod != nil {
+			recorder.Event(pod, eventtype, reason, fmt.Sprintf("%s %s: %s", kind, name, message))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Pod events", func() {
+
+	var recorder *record.FakeRecorder
+
+	podConfig := &podconfigv1alpha1.PodConfig{ObjectMeta: metav1.ObjectMeta{Name: "pc", Namespace: "default"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cnf", Namespace: "default"}}
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+	})
+
+	It("are recorded on the podconfig and the pod", func() {
+		event := podEvents(recorder, podConfig, pod)
+		event(corev1.EventTypeNormal, reasonBridgeCreated, "Created bridge %s on node", "br0")
+
+		Expect(recorder.Events).To(HaveLen(2))
+		Expect(<-recorder.Events).To(Equal("Normal BridgeCreated Pod cnf: Created bridge br0 on node"))
+		Expect(<-recorder.Events).To(Equal("Normal BridgeCreated Created bridge br0 on node"))
+	})
+
+	It("are recorded on the podconfig alone without a pod", func() {
+		event := podEvents(recorder, podConfig, nil)
+		event(corev1.EventTypeWarning, reasonConfigFailed, "Error setting sysctls: %v", "read-only")
+
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(Equal("Warning ConfigurationFailed Error setting sysctls: read-only"))
+	})
+
+	It("are only logged without a recorder", func() {
+		event := podEvents(nil, podConfig, pod)
+		Expect(func() {
+			event(corev1.EventTypeNormal, reasonSysctlSet, "Set sysctl %s to %q", "net.ipv4.ip_forward", "1")
+		}).NotTo(Panic())
+	})
+})
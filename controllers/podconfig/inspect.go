@@ -3,7 +3,10 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"google.golang.org/grpc"
 	corev1 "k8s.io/api/core/v1"
@@ -52,42 +55,148 @@ func parseCRIOContainerInfo(statusResponse *cri.ContainerStatusResponse) map[str
 	return parsedContainerInfo
 }
 
-func getPid(pod corev1.Pod) (string, error) {
+// errSandboxNotReady is returned while a pod has no ready sandbox or no
+// running container yet, the pod is configured once it has
+var errSandboxNotReady = errors.New("pod sandbox not ready")
 
-	// Get the container IDs for the given pod
-	containerIDs := getContainerIDs(pod)
+func isSandboxNotReady(err error) bool {
+	return errors.Is(err, errSandboxNotReady)
+}
+
+func getCRIOPodSandboxStatus(podUID string, grpcConn *grpc.ClientConn) (*cri.PodSandboxStatusResponse, error) {
+
+	criClient := cri.NewRuntimeServiceClient(grpcConn)
+
+	// Sandboxes are labeled with the uid of their pod by the kubelet
+	sandboxes, err := criClient.ListPodSandbox(context.Background(), &cri.ListPodSandboxRequest{
+		Filter: &cri.PodSandboxFilter{
+			State:         &cri.PodSandboxStateValue{State: cri.PodSandboxState_SANDBOX_READY},
+			LabelSelector: map[string]string{"io.kubernetes.pod.uid": podUID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(sandboxes.Items) == 0 {
+		return nil, errSandboxNotReady
+	}
+
+	// A restarted sandbox replaces the previous one, the latest is the live one
+	latest := sandboxes.Items[0]
+	for _, sandbox := range sandboxes.Items {
+		if sandbox.CreatedAt > latest.CreatedAt {
+			latest = sandbox
+		}
+	}
+
+	return criClient.PodSandboxStatus(context.Background(), &cri.PodSandboxStatusRequest{
+		PodSandboxId: latest.Id,
+		Verbose:      true,
+	})
+}
+
+func parseCRIOInfo(info map[string]string) map[string]interface{} {
+
+	var parsedInfo map[string]interface{}
+
+	json.Unmarshal([]byte(info["info"]), &parsedInfo)
+
+	return parsedInfo
+}
+
+// Resolves the pod network namespace through the pod sandbox, which holds
+// it for the whole life of the pod whatever its containers do. Runtimes
+// that don't run a process for the sandbox leave its pid out, the pid of
+// a running container of the pod is then used to enter the namespace.
+func getPodNetNS(pod corev1.Pod) (podNetNS, error) {
 
 	// Connect with CRI-O's grpc endpoint
 	conn, err := getCRIOConnection()
 	if err != nil {
-		return "", fmt.Errorf("Error getting CRIO connection: %v", err)
+		return podNetNS{}, fmt.Errorf("Error getting CRIO connection: %v", err)
 	}
+	defer conn.Close()
 
-	// Make a container status request to CRI-O
-	// Here it doesn't matter which container ID inside the pod.
-	// The goal is to put runtime configurations on Pod shared namespaces
-	// like network and mount. Not intended for process/container specific namespaces.
-
-	containerStatusResponse, err := getCRIOContainerStatus(containerIDs[0], conn)
+	sandboxStatus, err := getCRIOPodSandboxStatus(string(pod.ObjectMeta.UID), conn)
 	if err != nil {
-		return "", fmt.Errorf("Error getting CRIO container status: %v", err)
+		return podNetNS{}, fmt.Errorf("Error getting CRIO pod sandbox status: %w", err)
 	}
 
-	parsedContainerInfo := parseCRIOContainerInfo(containerStatusResponse)
+	netNS := netNSFromInfo(parseCRIOInfo(sandboxStatus.Info))
+	netNS.linkSuffix = podLinkSuffix(pod.UID)
+	if netNS.pid != "" {
+		return netNS, nil
+	}
 
-	return fmt.Sprintf("%.0f", parsedContainerInfo["pid"]), nil
+	containerID, ok := getRunningContainerID(pod)
+	if !ok {
+		return podNetNS{}, errSandboxNotReady
+	}
 
+	containerStatusResponse, err := getCRIOContainerStatus(containerID, conn)
+	if err != nil {
+		return podNetNS{}, fmt.Errorf("Error getting CRIO container status: %v", err)
+	}
+
+	containerNetNS := netNSFromInfo(parseCRIOContainerInfo(containerStatusResponse))
+	if containerNetNS.pid == "" {
+		return podNetNS{}, fmt.Errorf("no pid in the status of container %v", containerID)
+	}
+	netNS.pid = containerNetNS.pid
+	if netNS.path == "" {
+		netNS.path = containerNetNS.path
+	}
+	return netNS, nil
 }
 
-func getContainerIDs(pod corev1.Pod) []string {
+// The network namespace is entered through the path in the runtime spec,
+// the one the runtime created for the pod sandbox, when it can be reached
+// from the operator. Otherwise it is entered through the proc entry of the
+// process, which only lives as long as the process.
+func netNSFromInfo(info map[string]interface{}) podNetNS {
 
-	containerIDs := []string{}
+	netNS := podNetNS{}
+	if pid, ok := info["pid"].(float64); ok && pid > 0 {
+		netNS.pid = fmt.Sprintf("%.0f", pid)
+		netNS.path = procNetNSPath(netNS.pid)
+	}
 
-	// get container ID list
-	for _, containerStatus := range pod.Status.ContainerStatuses {
+	var runtimeSpec struct {
+		Linux struct {
+			Namespaces []struct {
+				Type string `json:"type"`
+				Path string `json:"path"`
+			} `json:"namespaces"`
+		} `json:"linux"`
+	}
+	raw, err := json.Marshal(info["runtimeSpec"])
+	if err != nil || json.Unmarshal(raw, &runtimeSpec) != nil {
+		return netNS
+	}
+	for _, namespace := range runtimeSpec.Linux.Namespaces {
+		if namespace.Type != "network" || namespace.Path == "" {
+			continue
+		}
+		if _, err := os.Stat(namespace.Path); err == nil {
+			netNS.path = namespace.Path
+		}
+	}
+	return netNS
+}
 
-		containerIDs = append(containerIDs, containerStatus.ContainerID[8:])
+// Container IDs are reported as <runtime>://<id> and are empty until the
+// container is created. Only running containers have a process to look at.
+func getRunningContainerID(pod corev1.Pod) (string, bool) {
+
+	for _, containerStatus := range pod.Status.ContainerStatuses {
 
+		if containerStatus.State.Running == nil {
+			continue
+		}
+		parts := strings.SplitN(containerStatus.ContainerID, "://", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			return parts[1], true
+		}
 	}
-	return containerIDs
+	return "", false
 }
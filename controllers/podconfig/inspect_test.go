@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Pod network namespaces", func() {
+
+	It("name the pod links after the pod uid", func() {
+		suffix := podLinkSuffix("8f3c2a64-1d2e-4c55-9a0e-6f1b7e2d9c10")
+		Expect(suffix).To(MatchRegexp("^[0-9a-f]{7}$"))
+		Expect(podLinkSuffix("8f3c2a64-1d2e-4c55-9a0e-6f1b7e2d9c10")).To(Equal(suffix))
+		Expect(podLinkSuffix("0b7d9e21-55c3-4f0a-8e7b-2a4c6d8e0f12")).NotTo(Equal(suffix))
+	})
+
+	Describe("from the runtime info", func() {
+
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "netns")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		info := func(pid float64, path string) map[string]interface{} {
+			return parseCRIOInfo(map[string]string{"info": `{
+				"pid": ` + fmt.Sprintf("%.0f", pid) + `,
+				"runtimeSpec": {"linux": {"namespaces": [
+					{"type": "ipc"},
+					{"type": "network", "path": "` + path + `"}
+				]}}
+			}`})
+		}
+
+		It("enter the namespace through the path of the runtime spec", func() {
+			path := filepath.Join(dir, "netns")
+			Expect(ioutil.WriteFile(path, nil, 0644)).To(Succeed())
+
+			Expect(netNSFromInfo(info(1234, path))).To(Equal(podNetNS{pid: "1234", path: path}))
+		})
+
+		It("fall back to the proc entry when the path can't be reached", func() {
+			netNS := netNSFromInfo(info(1234, filepath.Join(dir, "missing")))
+			Expect(netNS).To(Equal(podNetNS{pid: "1234", path: procNetNSPath("1234")}))
+		})
+
+		It("leave the pid out for runtimes without a sandbox process", func() {
+			netNS := netNSFromInfo(info(0, filepath.Join(dir, "missing")))
+			Expect(netNS).To(Equal(podNetNS{}))
+		})
+	})
+
+	It("are looked up through a running container", func() {
+		pod := corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{ContainerID: "cri-o://waiting", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}},
+			{ContainerID: "", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			{ContainerID: "cri-o://3f1e9a", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}}}
+
+		id, ok := getRunningContainerID(pod)
+		Expect(ok).To(BeTrue())
+		Expect(id).To(Equal("3f1e9a"))
+
+		_, ok = getRunningContainerID(corev1.Pod{})
+		Expect(ok).To(BeFalse())
+	})
+})
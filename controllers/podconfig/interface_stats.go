@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+)
+
+// podInterface is the pod side of an attachment, found in the network
+// namespace of the pod
+type podInterface struct {
+	netNS podNetNS
+	name  string
+}
+
+// podInterfaces keeps the pod side of every attachment configured on the
+// node by port alias. Pods are configured again on operator restart, which
+// fills it back in.
+type podInterfaces struct {
+	sync.Mutex
+	byAlias map[string]podInterface
+
+	// signaled when interfaces are added or removed
+	changed chan struct{}
+}
+
+var interfaces = &podInterfaces{byAlias: map[string]podInterface{}, changed: make(chan struct{}, 1)}
+
+func (p *podInterfaces) add(alias string, netNS podNetNS, name string) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.byAlias[alias] != (podInterface{netNS: netNS, name: name}) {
+		p.byAlias[alias] = podInterface{netNS: netNS, name: name}
+		p.notify()
+	}
+}
+
+func (p *podInterfaces) remove(alias string) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.byAlias[alias]; ok {
+		delete(p.byAlias, alias)
+		p.notify()
+	}
+}
+
+// Signals a change without blocking, pending signals are merged
+func (p *podInterfaces) notify() {
+
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (p *podInterfaces) list() map[string]podInterface {
+
+	p.Lock()
+	defer p.Unlock()
+
+	list := map[string]podInterface{}
+	for alias, iface := range p.byAlias {
+		list[alias] = iface
+	}
+	return list
+}
+
+var interfaceLabels = []string{"namespace", "pod", "podconfig", "attachment", "side"}
+
+// interfaceStat is one link statistic exported as a counter
+type interfaceStat struct {
+	desc  *prometheus.Desc
+	value func(s *netlink.LinkStatistics) uint64
+}
+
+func newInterfaceStat(name string, help string, value func(s *netlink.LinkStatistics) uint64) interfaceStat {
+	return interfaceStat{
+		desc:  prometheus.NewDesc("podconfig_interface_"+name, help, interfaceLabels, nil),
+		value: value,
+	}
+}
+
+var interfaceStats = []interfaceStat{
+	newInterfaceStat("rx_bytes_total", "Bytes received on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.RxBytes }),
+	newInterfaceStat("tx_bytes_total", "Bytes sent on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.TxBytes }),
+	newInterfaceStat("rx_packets_total", "Packets received on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.RxPackets }),
+	newInterfaceStat("tx_packets_total", "Packets sent on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.TxPackets }),
+	newInterfaceStat("rx_dropped_total", "Received packets dropped on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.RxDropped }),
+	newInterfaceStat("tx_dropped_total", "Sent packets dropped on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.TxDropped }),
+	newInterfaceStat("rx_errors_total", "Receive errors on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.RxErrors }),
+	newInterfaceStat("tx_errors_total", "Transmit errors on an attachment interface",
+		func(s *netlink.LinkStatistics) uint64 { return s.TxErrors }),
+}
+
+// interfaceCollector reads the statistics of the host and pod side of
+// every attachment at scrape time
+type interfaceCollector struct{}
+
+func (interfaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, stat := range interfaceStats {
+		ch <- stat.desc
+	}
+}
+
+func (interfaceCollector) Collect(ch chan<- prometheus.Metric) {
+
+	// A registry refuses the whole scrape on duplicate series, links that
+	// share an alias, such as a leftover of a recreated interface, are
+	// only collected once
+	seen := map[string]bool{}
+
+	// Host side links carry the port alias of their attachment
+	err := collectInNetNS(hostNetNSPath(), func() error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			owner, attachment, ok := parsePortAlias(link.Attrs().Alias)
+			if !ok {
+				continue
+			}
+			collectLinkStats(ch, seen, link, owner, attachment, "host")
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error collecting host interface statistics: %v\n", err)
+	}
+
+	// Pod side links are looked up in the pod network namespace
+	for alias, iface := range interfaces.list() {
+		owner, attachment, ok := parsePortAlias(alias)
+		if !ok {
+			continue
+		}
+		err := collectInNetNS(iface.netNS.path, func() error {
+			link, err := netlink.LinkByName(iface.name)
+			if err != nil {
+				return err
+			}
+			collectLinkStats(ch, seen, link, owner, attachment, "pod")
+			return nil
+		})
+		// The pod may be gone since the last sweep
+		if _, gone := err.(ns.NSPathNotExistErr); gone {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Error collecting statistics of %v: %v\n", iface.name, err)
+		}
+	}
+}
+
+// Like doInNetNS but returns the namespace errors as they are and isn't
+// timed as an apply step
+func collectInNetNS(path string, fn func() error) error {
+
+	targetNS, err := ns.GetNS(path)
+	if err != nil {
+		return err
+	}
+	defer targetNS.Close()
+
+	return targetNS.Do(func(ns.NetNS) error {
+		return fn()
+	})
+}
+
+func collectLinkStats(ch chan<- prometheus.Metric, seen map[string]bool, link netlink.Link, owner attachmentOwner, attachment string, side string) {
+
+	stats := link.Attrs().Statistics
+	if stats == nil {
+		return
+	}
+	labels := []string{owner.Namespace, owner.Pod, owner.PodConfig, attachment, side}
+	key := strings.Join(labels, "\x00")
+	if seen[key] {
+		fmt.Printf("Skipping statistics of %v, already collected for %v\n", link.Attrs().Name, labels)
+		return
+	}
+	seen[key] = true
+
+	for _, stat := range interfaceStats {
+		ch <- prometheus.MustNewConstMetric(stat.desc, prometheus.CounterValue, float64(stat.value(stats)), labels...)
+	}
+}
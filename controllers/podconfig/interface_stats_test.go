@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("Interface statistics", func() {
+
+	owner := attachmentOwner{Namespace: "default", PodConfig: "pc", Pod: "cnf"}
+
+	newLink := func(name string, stats *netlink.LinkStatistics) netlink.Link {
+		return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name, Statistics: stats}}
+	}
+
+	collect := func(links ...netlink.Link) []prometheus.Metric {
+		ch := make(chan prometheus.Metric, len(links)*len(interfaceStats))
+		seen := map[string]bool{}
+		for _, link := range links {
+			collectLinkStats(ch, seen, link, owner, "net1", "pod")
+		}
+		close(ch)
+
+		metrics := []prometheus.Metric{}
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		return metrics
+	}
+
+	It("exports every statistic of a link with the attachment labels", func() {
+		metrics := collect(newLink("net1", &netlink.LinkStatistics{RxBytes: 1500, TxPackets: 3}))
+		Expect(metrics).To(HaveLen(len(interfaceStats)))
+
+		m := &dto.Metric{}
+		Expect(metrics[0].Write(m)).To(Succeed())
+		Expect(m.GetCounter().GetValue()).To(Equal(float64(1500)))
+
+		labels := map[string]string{}
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		Expect(labels).To(Equal(map[string]string{
+			"namespace": "default", "pod": "cnf", "podconfig": "pc", "attachment": "net1", "side": "pod",
+		}))
+	})
+
+	It("collects an attachment side only once per scrape", func() {
+		stats := &netlink.LinkStatistics{RxBytes: 1500}
+		Expect(collect(newLink("net1", stats), newLink("net1", stats))).To(HaveLen(len(interfaceStats)))
+	})
+
+	It("skips links without statistics", func() {
+		Expect(collect(newLink("net1", nil))).To(BeEmpty())
+	})
+
+	It("hands out a copy of the pod interfaces", func() {
+		p := &podInterfaces{byAlias: map[string]podInterface{}}
+		netNS := podNetNS{pid: "1234", path: procNetNSPath("1234")}
+		p.add("port", netNS, "net1")
+
+		list := p.list()
+		p.remove("port")
+		Expect(list).To(HaveKeyWithValue("port", podInterface{netNS: netNS, name: "net1"}))
+		Expect(p.list()).To(BeEmpty())
+	})
+})
@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/vishvananda/netlink"
 )
@@ -11,10 +13,15 @@ import (
 // Needs a real IPAM software
 
 type ipsInUse struct {
+	sync.Mutex
 	ipList []string
+	// owner of each allocated ip, either a port alias or a bridge owner
+	owners map[string]string
+	// cidr each allocated ip was taken from
+	networks map[string]string
 }
 
-var ips = &ipsInUse{ipList: []string{}}
+var ips = &ipsInUse{ipList: []string{}, owners: map[string]string{}, networks: map[string]string{}}
 
 func (ips *ipsInUse) Contains(ip net.IP) bool {
 
@@ -26,24 +33,90 @@ func (ips *ipsInUse) Contains(ip net.IP) bool {
 	return false
 }
 
-func (ips *ipsInUse) AllocateIP(ip net.IP) {
+func (ips *ipsInUse) AllocateIP(ip net.IP, network *net.IPNet, owner string) {
 
 	ips.ipList = append(ips.ipList, fmt.Sprintf("%v", ip))
+	ips.owners[fmt.Sprintf("%v", ip)] = owner
+	ips.networks[fmt.Sprintf("%v", ip)] = network.String()
 
 }
 
-func (ips *ipsInUse) getFreeIP(network string) *netlink.Addr {
+// Releases all ips allocated to an owner and returns them
+func (ips *ipsInUse) ReleaseOwner(owner string) []string {
 
-	ipv4Addr, _, _ := net.ParseCIDR(network)
-	ip := net.ParseIP(fmt.Sprintf("%v", ipv4Addr))
-	ip = ip.To4()
+	ips.Lock()
+	defer ips.Unlock()
 
-	for i := 1; i <= 254; i++ {
-		ip[3]++
+	released := []string{}
+	ipList := []string{}
+
+	for _, element := range ips.ipList {
+		if ips.owners[element] == owner {
+			released = append(released, element)
+			delete(ips.owners, element)
+			delete(ips.networks, element)
+			continue
+		}
+		ipList = append(ipList, element)
+	}
+	ips.ipList = ipList
+
+	return released
+}
+
+// Returns the distinct owners of the allocated ips
+func (ips *ipsInUse) Owners() []string {
+
+	ips.Lock()
+	defer ips.Unlock()
+
+	seen := map[string]bool{}
+	owners := []string{}
+
+	for _, owner := range ips.owners {
+		if !seen[owner] {
+			seen[owner] = true
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// Returns the number of allocated ips per cidr
+func (ips *ipsInUse) Usage() map[string]int {
+
+	ips.Lock()
+	defer ips.Unlock()
+
+	usage := map[string]int{}
+	for _, network := range ips.networks {
+		usage[network]++
+	}
+	return usage
+}
+
+// Hands out the first free host address of the network, nil when there is
+// none left. The network and broadcast addresses are never handed out.
+func (ips *ipsInUse) getFreeIP(network string, owner string) *netlink.Addr {
+
+	ips.Lock()
+	defer ips.Unlock()
+
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil || ipNet.IP.To4() == nil {
+		return nil
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+	size := uint32(1) << uint(32-prefixLen)
+	for n := uint32(1); n+1 < size; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+n)
 		if !ips.Contains(ip) {
 
-			ips.AllocateIP(ip)
-			addr, _ := netlink.ParseAddr(fmt.Sprintf("%v/24", ip))
+			ips.AllocateIP(ip, ipNet, owner)
+			addr, _ := netlink.ParseAddr(fmt.Sprintf("%v/%d", ip, prefixLen))
 			return addr
 
 		}
@@ -51,3 +124,44 @@ func (ips *ipsInUse) getFreeIP(network string) *netlink.Addr {
 	}
 	return nil
 }
+
+// Reserves a given address of the network so that it is never
+// handed out by getFreeIP. An address reserved by another owner is refused.
+func (ips *ipsInUse) getStaticIP(network string, address string, owner string) (*netlink.Addr, error) {
+
+	ips.Lock()
+	defer ips.Unlock()
+
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %q: %v", network, err)
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil || !ipNet.Contains(ip) {
+		return nil, fmt.Errorf("address %q is not part of %q", address, network)
+	}
+
+	if ips.Contains(ip) {
+		if current := ips.owners[fmt.Sprintf("%v", ip)]; current != owner {
+			return nil, fmt.Errorf("address %q is already in use by %v", address, current)
+		}
+	} else {
+		ips.AllocateIP(ip, ipNet, owner)
+	}
+
+	prefixLen, _ := ipNet.Mask.Size()
+	return netlink.ParseAddr(fmt.Sprintf("%v/%d", ip, prefixLen))
+}
+
+// Reserves the address found on an existing bridge for its owner, so that it
+// is not handed out to a pod after an operator restart. A nil gateway, for
+// bridges without an address in the network, reserves nothing.
+func (ips *ipsInUse) reserveGateway(network string, gateway net.IP, owner string) error {
+
+	if gateway == nil {
+		return nil
+	}
+	_, err := ips.getStaticIP(network, gateway.String(), owner)
+	return err
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IP allocation", func() {
+
+	var pool *ipsInUse
+
+	BeforeEach(func() {
+		pool = &ipsInUse{ipList: []string{}, owners: map[string]string{}, networks: map[string]string{}}
+	})
+
+	// Addresses are taken from the /24 they belong to
+	allocate := func(owner string, addresses ...string) {
+		for _, address := range addresses {
+			_, network, _ := net.ParseCIDR(address + "/24")
+			pool.AllocateIP(net.ParseIP(address), network, owner)
+		}
+	}
+
+	DescribeTable("free addresses",
+		func(network string, allocated []string, expected string) {
+			allocate("other", allocated...)
+
+			addr := pool.getFreeIP(network, "port")
+			if expected == "" {
+				Expect(addr).To(BeNil())
+				return
+			}
+			Expect(addr).NotTo(BeNil())
+			Expect(addr.String()).To(Equal(expected))
+			Expect(pool.owners).To(HaveKeyWithValue(addr.IP.String(), "port"))
+		},
+		Entry("start after the network address", "192.168.100.0/24", nil, "192.168.100.1/24"),
+		Entry("skip allocated addresses", "192.168.100.0/24", []string{"192.168.100.1", "192.168.100.2"}, "192.168.100.3/24"),
+		Entry("start at the network of smaller prefixes", "10.1.0.248/29", nil, "10.1.0.249/29"),
+		Entry("stay within smaller prefixes", "10.1.0.248/29",
+			[]string{"10.1.0.249", "10.1.0.250", "10.1.0.251", "10.1.0.252", "10.1.0.253", "10.1.0.254"}, ""),
+		Entry("never hand out the broadcast address", "192.168.100.0/30", []string{"192.168.100.1", "192.168.100.2"}, ""),
+		Entry("reject ipv6 networks", "fd00::/64", nil, ""),
+	)
+
+	Describe("static addresses", func() {
+
+		It("reserves an address of the network", func() {
+			addr, err := pool.getStaticIP("192.168.100.0/24", "192.168.100.254", "bridge")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addr.String()).To(Equal("192.168.100.254/24"))
+			Expect(pool.owners).To(HaveKeyWithValue("192.168.100.254", "bridge"))
+		})
+
+		It("returns an address reserved earlier by the same owner", func() {
+			allocate("bridge", "192.168.100.1")
+			addr, err := pool.getStaticIP("192.168.100.0/24", "192.168.100.1", "bridge")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addr.String()).To(Equal("192.168.100.1/24"))
+		})
+
+		It("refuses an address of another owner", func() {
+			allocate("port", "192.168.100.1")
+			_, err := pool.getStaticIP("192.168.100.0/24", "192.168.100.1", "bridge")
+			Expect(err).To(MatchError(ContainSubstring("already in use by port")))
+		})
+
+		It("refuses addresses outside of the network", func() {
+			_, err := pool.getStaticIP("192.168.100.0/24", "10.0.0.1", "bridge")
+			Expect(err).To(HaveOccurred())
+			_, err = pool.getStaticIP("192.168.100.0/24", "gateway", "bridge")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("gateways of existing bridges", func() {
+
+		It("are not handed out to pods", func() {
+			Expect(pool.reserveGateway("192.168.100.0/24", net.ParseIP("192.168.100.1"), "bridge")).To(Succeed())
+			Expect(pool.getFreeIP("192.168.100.0/24", "port").String()).To(Equal("192.168.100.2/24"))
+		})
+
+		It("are refused when a pod got them first", func() {
+			allocate("port", "192.168.100.1")
+			Expect(pool.reserveGateway("192.168.100.0/24", net.ParseIP("192.168.100.1"), "bridge")).NotTo(Succeed())
+		})
+
+		It("reserve nothing for L2 only bridges", func() {
+			Expect(pool.reserveGateway("192.168.100.0/24", nil, "bridge")).To(Succeed())
+			Expect(pool.getFreeIP("192.168.100.0/24", "port").String()).To(Equal("192.168.100.1/24"))
+		})
+	})
+
+	It("releases every address of an owner", func() {
+		allocate("port", "192.168.100.1", "192.168.99.1")
+		allocate("bridge", "192.168.100.254")
+
+		Expect(pool.ReleaseOwner("port")).To(ConsistOf("192.168.100.1", "192.168.99.1"))
+		Expect(pool.ipList).To(ConsistOf("192.168.100.254"))
+		Expect(pool.Owners()).To(ConsistOf("bridge"))
+	})
+
+	It("counts the allocated addresses per cidr", func() {
+		allocate("port", "192.168.100.1", "192.168.99.1")
+		Expect(pool.getStaticIP("192.168.100.0/24", "192.168.100.254", "bridge")).NotTo(BeNil())
+		Expect(pool.getFreeIP("10.1.0.248/29", "port")).NotTo(BeNil())
+
+		Expect(pool.Usage()).To(Equal(map[string]int{"192.168.100.0/24": 2, "192.168.99.0/24": 1, "10.1.0.248/29": 1}))
+
+		pool.ReleaseOwner("port")
+		Expect(pool.Usage()).To(Equal(map[string]int{"192.168.100.0/24": 1}))
+	})
+})
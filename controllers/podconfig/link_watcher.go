@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+)
+
+// LinkWatcher subscribes to link, address and route updates in the host
+// network namespace and in the network namespace of every pod configured
+// on the node. Updates that undo part of an attachment configuration, such
+// as a deleted link, a link set down or a removed address or route, send
+// the owning podconfig to Events so that it is reconciled right away
+// instead of on the next resync.
+type LinkWatcher struct {
+	Log    logr.Logger
+	Events chan<- event.GenericEvent
+
+	// Interval between checks for pods that went away without their
+	// interfaces being removed, their subscriptions are closed
+	Interval time.Duration
+}
+
+// netNSWatch is the subscription to one network namespace
+type netNSWatch struct {
+	handle *netlink.Handle
+	done   chan struct{}
+}
+
+// Start watches until the stop channel is closed
+func (w *LinkWatcher) Start(stop <-chan struct{}) error {
+
+	host, err := w.watch(hostNetNSPath())
+	if err != nil {
+		return fmt.Errorf("error watching host network namespace: %v", err)
+	}
+	defer host.close()
+
+	pods := map[string]*netNSWatch{}
+	defer func() {
+		for _, pod := range pods {
+			pod.close()
+		}
+	}()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.syncPods(pods)
+
+		select {
+		case <-stop:
+			return nil
+		case <-interfaces.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection is false since every node has its own links
+func (w *LinkWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Subscribes to the pods with configured interfaces and closes the
+// subscriptions of pods that have none left or whose namespace is gone.
+// An open subscription keeps the network namespace of the pod alive.
+func (w *LinkWatcher) syncPods(pods map[string]*netNSWatch) {
+
+	paths := map[string]bool{}
+	for _, iface := range interfaces.list() {
+		paths[iface.netNS.path] = true
+	}
+
+	for path, pod := range pods {
+		if _, err := os.Stat(path); paths[path] && err == nil {
+			continue
+		}
+		pod.close()
+		delete(pods, path)
+	}
+
+	for path := range paths {
+		if pods[path] != nil {
+			continue
+		}
+		pod, err := w.watch(path)
+		if err != nil {
+			w.Log.Error(err, "error watching pod network namespace", "netns", path)
+			continue
+		}
+		pods[path] = pod
+	}
+}
+
+// Subscribes to the network namespace at path
+func (w *LinkWatcher) watch(path string) (*netNSWatch, error) {
+
+	nsHandle, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	// The sockets hold the network namespace from here on
+	defer nsHandle.Close()
+
+	handle, err := netlink.NewHandleAt(nsHandle)
+	if err != nil {
+		return nil, err
+	}
+	watch := &netNSWatch{handle: handle, done: make(chan struct{})}
+
+	// Closing the subscriptions makes them fail as well. A failed
+	// subscription isn't restarted, changes are then found on resync.
+	onError := func(err error) {
+		select {
+		case <-watch.done:
+		default:
+			w.Log.Error(err, "netlink subscription failed", "netns", path)
+		}
+	}
+
+	links := make(chan netlink.LinkUpdate)
+	addrs := make(chan netlink.AddrUpdate)
+	routes := make(chan netlink.RouteUpdate)
+
+	err = netlink.LinkSubscribeWithOptions(links, watch.done, netlink.LinkSubscribeOptions{Namespace: &nsHandle, ErrorCallback: onError})
+	if err == nil {
+		err = netlink.AddrSubscribeWithOptions(addrs, watch.done, netlink.AddrSubscribeOptions{Namespace: &nsHandle, ErrorCallback: onError})
+	}
+	if err == nil {
+		err = netlink.RouteSubscribeWithOptions(routes, watch.done, netlink.RouteSubscribeOptions{Namespace: &nsHandle, ErrorCallback: onError})
+	}
+	if err != nil {
+		watch.close()
+		return nil, err
+	}
+
+	go w.handle(path, watch, links, addrs, routes)
+	return watch, nil
+}
+
+func (n *netNSWatch) close() {
+	close(n.done)
+	n.handle.Delete()
+}
+
+// Maps the updates of one network namespace to podconfigs. The update
+// channels are closed by netlink when the subscriptions end.
+func (w *LinkWatcher) handle(path string, watch *netNSWatch, links <-chan netlink.LinkUpdate, addrs <-chan netlink.AddrUpdate, routes <-chan netlink.RouteUpdate) {
+
+	for links != nil || addrs != nil || routes != nil {
+		select {
+		case update, ok := <-links:
+			if !ok {
+				links = nil
+				continue
+			}
+			if update.Header.Type == unix.RTM_DELLINK || update.Attrs().Flags&net.FlagUp == 0 ||
+				(path == hostNetNSPath() && update.Attrs().MasterIndex == 0) {
+				w.linkChanged(path, watch, update.Link, "link "+update.Attrs().Name+" changed")
+			}
+
+		case update, ok := <-addrs:
+			if !ok {
+				addrs = nil
+				continue
+			}
+			if !update.NewAddr {
+				w.indexChanged(path, watch, update.LinkIndex, "address "+update.LinkAddress.String()+" removed")
+			}
+
+		case update, ok := <-routes:
+			if !ok {
+				routes = nil
+				continue
+			}
+			if update.Type == unix.RTM_DELROUTE {
+				w.indexChanged(path, watch, update.LinkIndex, fmt.Sprintf("route to %v removed", update.Dst))
+			}
+		}
+	}
+}
+
+func (w *LinkWatcher) indexChanged(path string, watch *netNSWatch, index int, change string) {
+
+	link, err := watch.handle.LinkByIndex(index)
+	if err != nil {
+		// The link is gone, its own update is handled
+		return
+	}
+	w.linkChanged(path, watch, link, change)
+}
+
+// Enqueues the podconfigs owning a link. Host veths carry the owner in their
+// alias, pod veths are looked up in the interfaces configured on the node
+// and bridges are mapped through their ports.
+func (w *LinkWatcher) linkChanged(path string, watch *netNSWatch, link netlink.Link, change string) {
+
+	if path != hostNetNSPath() {
+		for alias, iface := range interfaces.list() {
+			if iface.netNS.path == path && iface.name == link.Attrs().Name {
+				w.enqueue(alias, change)
+			}
+		}
+		return
+	}
+
+	if link.Type() == "bridge" && isOwnedBridge(link.Attrs().Alias) {
+		ports, err := watch.handle.LinkList()
+		if err != nil {
+			w.Log.Error(err, "failed to list bridge ports", "bridge", link.Attrs().Name)
+			return
+		}
+		for _, port := range ports {
+			if port.Attrs().MasterIndex == link.Attrs().Index {
+				w.enqueue(port.Attrs().Alias, change)
+			}
+		}
+		return
+	}
+
+	w.enqueue(link.Attrs().Alias, change)
+}
+
+func (w *LinkWatcher) enqueue(alias string, change string) {
+
+	owner, _, ok := parsePortAlias(alias)
+	if !ok {
+		return
+	}
+	fmt.Printf("Reconciling podconfig %s/%s, %s\n", owner.Namespace, owner.PodConfig, change)
+
+	podConfig := &podconfigv1alpha1.PodConfig{
+		ObjectMeta: metav1.ObjectMeta{Namespace: owner.Namespace, Name: owner.PodConfig},
+	}
+	w.Events <- event.GenericEvent{Meta: podConfig, Object: podConfig}
+}
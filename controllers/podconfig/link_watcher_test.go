@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Link watcher", func() {
+
+	var (
+		events chan event.GenericEvent
+		w      *LinkWatcher
+		saved  *podInterfaces
+	)
+
+	owner := attachmentOwner{Namespace: "tenant", PodConfig: "pc", Pod: "cnf"}
+
+	BeforeEach(func() {
+		events = make(chan event.GenericEvent, 10)
+		w = &LinkWatcher{Events: events}
+
+		saved = interfaces
+		interfaces = &podInterfaces{byAlias: map[string]podInterface{}}
+	})
+
+	AfterEach(func() {
+		interfaces = saved
+	})
+
+	It("reconciles the podconfig owning a host veth", func() {
+		w.enqueue(owner.portAlias("net1"), "link pc0 changed")
+
+		Expect(events).To(HaveLen(1))
+		e := <-events
+		Expect(e.Meta.GetNamespace()).To(Equal("tenant"))
+		Expect(e.Meta.GetName()).To(Equal("pc"))
+	})
+
+	It("ignores links not created by the operator", func() {
+		w.enqueue("", "link eth0 changed")
+		w.enqueue(bridgeOwner("br0"), "link br0 changed")
+
+		Expect(events).To(BeEmpty())
+	})
+
+	It("maps pod interfaces through the interfaces configured on the node", func() {
+		interfaces.add(owner.portAlias("net1"), podNetNS{pid: "1234", path: procNetNSPath("1234")}, "net1")
+		link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1"}}
+
+		w.linkChanged(procNetNSPath("1234"), nil, link, "address 192.168.100.2/24 removed")
+		w.linkChanged(procNetNSPath("5678"), nil, link, "address 192.168.100.2/24 removed")
+
+		Expect(events).To(HaveLen(1))
+		Expect((<-events).Meta.GetName()).To(Equal("pc"))
+	})
+})
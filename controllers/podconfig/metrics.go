@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// Host resources removed by the sweeper, by kind: veth, bridge or ip
+	sweeperReclaimed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "podconfig_sweeper_reclaimed_total",
+			Help: "Number of orphaned host resources reclaimed by the sweeper",
+		},
+		[]string{"node", "kind"},
+	)
+
+	// Pods of a podconfig configured on the node by the last reconcile
+	configuredPods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "podconfig_configured_pods",
+			Help: "Number of pods configured by a podconfig on the node",
+		},
+		[]string{"node", "namespace", "podconfig"},
+	)
+
+	// Network attachments of a podconfig configured on the node
+	configuredAttachments = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "podconfig_configured_attachments",
+			Help: "Number of network attachments configured by a podconfig on the node",
+		},
+		[]string{"node", "namespace", "podconfig"},
+	)
+
+	// Operator created bridges found on the node by the sweeper
+	nodeBridges = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "podconfig_bridges",
+			Help: "Number of operator created bridges on the node",
+		},
+		[]string{"node"},
+	)
+
+	// Time to configure a pod, by result: success or failure
+	applyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "podconfig_apply_duration_seconds",
+			Help:    "Time spent applying a podconfig to a pod",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+
+	// Time spent on each apply step: cri_lookup, netns_entry or the
+	// operation of a transaction step (create, set, add, attach, ...)
+	applyStepDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "podconfig_apply_step_duration_seconds",
+			Help:    "Time spent on each step of applying a podconfig to a pod",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		},
+		[]string{"step"},
+	)
+
+	// Failures by the reason of the warning event reporting them
+	failures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "podconfig_failures_total",
+			Help: "Number of podconfig failures by reason",
+		},
+		[]string{"reason"},
+	)
+
+	ipamAllocatedDesc = prometheus.NewDesc(
+		"podconfig_ipam_allocated_addresses",
+		"Number of addresses allocated from an attachment cidr",
+		[]string{"cidr"}, nil,
+	)
+
+	ipamUtilizationDesc = prometheus.NewDesc(
+		"podconfig_ipam_utilization_ratio",
+		"Share of the usable addresses of an attachment cidr that are allocated",
+		[]string{"cidr"}, nil,
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		sweeperReclaimed,
+		configuredPods,
+		configuredAttachments,
+		nodeBridges,
+		applyDuration,
+		applyStepDuration,
+		failures,
+		ipamCollector{},
+		interfaceCollector{},
+	)
+}
+
+// Observes the time spent on an apply step since start
+func observeStep(step string, start time.Time) {
+	applyStepDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// Transaction steps are named after their operation, e.g. "create veth pc0"
+func stepOperation(name string) string {
+	return strings.SplitN(name, " ", 2)[0]
+}
+
+// ipamCollector reports the ip pool usage at scrape time
+type ipamCollector struct{}
+
+func (ipamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ipamAllocatedDesc
+	ch <- ipamUtilizationDesc
+}
+
+func (ipamCollector) Collect(ch chan<- prometheus.Metric) {
+
+	for cidr, allocated := range ips.Usage() {
+		ch <- prometheus.MustNewConstMetric(ipamAllocatedDesc, prometheus.GaugeValue, float64(allocated), cidr)
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		// Network and broadcast addresses are never handed out
+		ones, bits := ipNet.Mask.Size()
+		usable := float64(uint64(1)<<uint(bits-ones)) - 2
+		if usable > 0 {
+			ch <- prometheus.MustNewConstMetric(ipamUtilizationDesc, prometheus.GaugeValue, float64(allocated)/usable, cidr)
+		}
+	}
+}
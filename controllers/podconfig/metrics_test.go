@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"net"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Metrics", func() {
+
+	It("name transaction steps after their operation", func() {
+		Expect(stepOperation("create veth pc0")).To(Equal("create"))
+		Expect(stepOperation("rollback")).To(Equal("rollback"))
+	})
+
+	It("count warning events as failures by reason", func() {
+		before := testutil.ToFloat64(failures.WithLabelValues(reasonConfigFailed))
+
+		event := podEvents(nil, nil, nil)
+		event(corev1.EventTypeWarning, reasonConfigFailed, "Error creating network attachments: %v", "busy")
+		event(corev1.EventTypeNormal, reasonConfigFailed, "not a failure")
+
+		Expect(testutil.ToFloat64(failures.WithLabelValues(reasonConfigFailed))).To(Equal(before + 1))
+	})
+
+	Describe("ipam collector", func() {
+
+		var saved *ipsInUse
+
+		BeforeEach(func() {
+			saved = ips
+			ips = &ipsInUse{ipList: []string{}, owners: map[string]string{}, networks: map[string]string{}}
+		})
+
+		AfterEach(func() {
+			ips = saved
+		})
+
+		It("reports allocations and utilization per cidr", func() {
+			_, network, _ := net.ParseCIDR("192.168.100.0/30")
+			ips.AllocateIP(net.ParseIP("192.168.100.1"), network, "port")
+
+			expected := `
+# HELP podconfig_ipam_allocated_addresses Number of addresses allocated from an attachment cidr
+# TYPE podconfig_ipam_allocated_addresses gauge
+podconfig_ipam_allocated_addresses{cidr="192.168.100.0/30"} 1
+# HELP podconfig_ipam_utilization_ratio Share of the usable addresses of an attachment cidr that are allocated
+# TYPE podconfig_ipam_utilization_ratio gauge
+podconfig_ipam_utilization_ratio{cidr="192.168.100.0/30"} 0.5
+`
+			Expect(testutil.CollectAndCompare(ipamCollector{}, strings.NewReader(expected))).To(Succeed())
+		})
+
+		It("skips the utilization of cidrs without usable addresses", func() {
+			_, network, _ := net.ParseCIDR("192.168.100.1/32")
+			ips.AllocateIP(net.ParseIP("192.168.100.1"), network, "port")
+
+			expected := `
+# HELP podconfig_ipam_allocated_addresses Number of addresses allocated from an attachment cidr
+# TYPE podconfig_ipam_allocated_addresses gauge
+podconfig_ipam_allocated_addresses{cidr="192.168.100.1/32"} 1
+`
+			Expect(testutil.CollectAndCompare(ipamCollector{}, strings.NewReader(expected))).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Flags of the open_tree and move_mount system calls, not in x/sys yet
+const (
+	openTreeClone       = 0x1
+	openTreeCloseOnExec = unix.O_CLOEXEC
+	atEmptyPath         = unix.AT_EMPTY_PATH
+	atRecursive         = 0x8000
+	moveMountFEmptyPath = 0x4
+)
+
+// Working directory for the *at system calls, a variable since a negative
+// constant can't be passed as a system call argument
+var atFDCWD = unix.AT_FDCWD
+
+// AllowedHostPaths podconfigs may bind mount. The webhook checks them on
+// admission and they are checked again before every mount, podconfigs may
+// have been admitted with other options or without the webhook.
+var AllowedHostPaths []string
+
+// Bind mounts the host paths of the podconfig mounts into their target
+// containers and unmounts the recorded ones that are no longer in the
+// podconfig. Mounts already in place are left as they are. Returns the
+// mounts in place as <container>:<path>.
+func applyMounts(pod corev1.Pod, mounts []podconfigv1alpha1.MountSpec, recorded []string, event eventFunc) ([]string, error) {
+
+	applied := []string{}
+	if len(mounts) == 0 && len(recorded) == 0 {
+		return applied, nil
+	}
+
+	conn, err := getCRIOConnection()
+	if err != nil {
+		return applied, fmt.Errorf("Error getting CRIO connection: %v", err)
+	}
+	defer conn.Close()
+
+	removed := []string{}
+	for _, mount := range recorded {
+		if !containsMount(mounts, mount) {
+			removed = append(removed, mount)
+		}
+	}
+	if err := unmountRecorded(pod, removed, conn, event); err != nil {
+		return applied, err
+	}
+
+	for _, mount := range mounts {
+
+		if !filepath.IsAbs(mount.HostPath) || !podconfigv1alpha1.HostPathAllowed(mount.HostPath, AllowedHostPaths) {
+			return applied, fmt.Errorf("host path %v is not allowed", mount.HostPath)
+		}
+
+		pid, err := resolveTarget(pod, mount.Target, conn)
+		if err != nil {
+			return applied, err
+		}
+
+		mounted, err := isMountPoint(pid, mount.ContainerPath)
+		if err != nil {
+			return applied, err
+		}
+		if !mounted {
+			err = bindMount(pid, mount.HostPath, mount.ContainerPath, mount.ReadOnly)
+			if err != nil {
+				return applied, fmt.Errorf("failed to mount %v on %v in container %v: %v", mount.HostPath, mount.ContainerPath, mount.Target.Container, err)
+			}
+			event(corev1.EventTypeNormal, reasonBindMounted, "Mounted %s on %s in container %s", mount.HostPath, mount.ContainerPath, mount.Target.Container)
+		}
+		applied = append(applied, mountName(mount.Target.Container, mount.ContainerPath))
+	}
+	return applied, nil
+}
+
+// Recorded name of a mount
+func mountName(container string, path string) string {
+	return container + ":" + filepath.Clean(path)
+}
+
+func containsMount(mounts []podconfigv1alpha1.MountSpec, name string) bool {
+	for _, mount := range mounts {
+		if mountName(mount.Target.Container, mount.ContainerPath) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmounts recorded mounts from their containers. Mounts of containers that
+// are not running went away with their mount namespace.
+func unmountRecorded(pod corev1.Pod, recorded []string, conn *grpc.ClientConn, event eventFunc) error {
+
+	for _, name := range recorded {
+
+		parts := strings.SplitN(name, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		container, path := parts[0], parts[1]
+
+		pid, err := resolveTarget(pod, podconfigv1alpha1.TargetSpec{Container: container}, conn)
+		if err != nil {
+			fmt.Printf("Not unmounting %v from container %v: %v\n", path, container, err)
+			continue
+		}
+		mounted, err := isMountPoint(pid, path)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			continue
+		}
+
+		err = doInMountNS(pid, func() error {
+			return unix.Unmount(path, unix.MNT_DETACH)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to unmount %v from container %v: %v", path, container, err)
+		}
+		event(corev1.EventTypeNormal, reasonUnmounted, "Unmounted %s from container %s", path, container)
+	}
+	return nil
+}
+
+// Unmounts every mount recorded for a pod that is still running
+func unmountAll(pod corev1.Pod, recorded []string, event eventFunc) error {
+
+	if len(recorded) == 0 || pod.Status.Phase != corev1.PodRunning {
+		return nil
+	}
+
+	conn, err := getCRIOConnection()
+	if err != nil {
+		return fmt.Errorf("Error getting CRIO connection: %v", err)
+	}
+	defer conn.Close()
+
+	return unmountRecorded(pod, recorded, conn, event)
+}
+
+// Whether path is a mount point in the mount namespace of a process
+func isMountPoint(pid string, path string) (bool, error) {
+
+	mountInfo, err := os.Open(filepath.Join(HostProc, pid, "mountinfo"))
+	if err != nil {
+		return false, err
+	}
+	defer mountInfo.Close()
+
+	path = filepath.Clean(path)
+	scanner := bufio.NewScanner(mountInfo)
+	for scanner.Scan() {
+		// The fifth field is the mount point
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 5 && fields[4] == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Bind mounts a host path into the mount namespace of a process. Mount
+// namespaces can't be entered by a multithreaded process, only by a single
+// thread that doesn't share its file system attributes. The mount is done
+// from a locked thread that unshares them. It is never unlocked, so that it
+// exits with its goroutine instead of going back to the runtime in the
+// container mount namespace.
+func bindMount(pid string, hostPath string, containerPath string, readOnly bool) error {
+
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		result <- bindMountOnThread(pid, hostPath, containerPath, readOnly)
+	}()
+	return <-result
+}
+
+func bindMountOnThread(pid string, hostPath string, containerPath string, readOnly bool) error {
+
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return fmt.Errorf("failed to unshare file system attributes: %v", err)
+	}
+
+	hostNS, err := os.Open(procNSPath("1", "mnt"))
+	if err != nil {
+		return err
+	}
+	defer hostNS.Close()
+
+	targetNS, err := os.Open(procNSPath(pid, "mnt"))
+	if err != nil {
+		return err
+	}
+	defer targetNS.Close()
+
+	// The mount tree is cloned in the host mount namespace, a mount
+	// can only be cloned from the namespace it belongs to
+	if err := unix.Setns(int(hostNS.Fd()), unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("failed to enter host mount namespace: %v", err)
+	}
+	source, err := openHostPath(hostPath)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(source)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(source, &stat); err != nil {
+		return err
+	}
+	tree, err := openTree(source)
+	if err != nil {
+		return fmt.Errorf("failed to clone %v: %v", hostPath, err)
+	}
+	defer unix.Close(tree)
+
+	// and attached in the container one
+	if err := unix.Setns(int(targetNS.Fd()), unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("failed to enter container mount namespace: %v", err)
+	}
+	if err := createMountPoint(containerPath, stat.Mode&unix.S_IFMT == unix.S_IFDIR); err != nil {
+		return err
+	}
+	if err := moveMount(tree, containerPath); err != nil {
+		return err
+	}
+
+	if readOnly {
+		return unix.Mount("", containerPath, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
+	}
+	return nil
+}
+
+// Directories are mounted on directories and files on files
+func createMountPoint(path string, dir bool) error {
+
+	if dir {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Opens a host path once its symlinks are resolved, from the host mount
+// namespace. The path the file descriptor refers to is checked against the
+// allowed host paths, so that neither a symlink below an allowed path nor
+// one swapped in after the resolution leads out of them.
+func openHostPath(hostPath string) (int, error) {
+
+	resolved, err := filepath.EvalSymlinks(hostPath)
+	if err != nil {
+		return -1, err
+	}
+	fd, err := unix.Open(resolved, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	opened, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err == nil && !podconfigv1alpha1.HostPathAllowed(opened, AllowedHostPaths) {
+		err = fmt.Errorf("host path %v resolves to %v, which is not allowed", hostPath, opened)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// Clones the mount tree of an open file
+func openTree(fd int) (int, error) {
+
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return -1, err
+	}
+	tree, _, errno := unix.Syscall(unix.SYS_OPEN_TREE, uintptr(fd), uintptr(unsafe.Pointer(empty)),
+		uintptr(openTreeClone|openTreeCloseOnExec|atEmptyPath|atRecursive))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(tree), nil
+}
+
+func moveMount(tree int, path string) error {
+
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_MOVE_MOUNT, uintptr(tree), uintptr(unsafe.Pointer(empty)),
+		uintptr(atFDCWD), uintptr(unsafe.Pointer(p)), moveMountFEmptyPath, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to attach mount on %v: %v", path, errno)
+	}
+	return nil
+}
+
+// Runs fn in the mount namespace of a process, from a locked thread that
+// is never unlocked as for bind mounts. Paths are resolved in the root of
+// the container. File descriptors opened by fn can be used from any thread.
+func doInMountNS(pid string, fn func() error) error {
+
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			result <- fmt.Errorf("failed to unshare file system attributes: %v", err)
+			return
+		}
+		targetNS, err := os.Open(procNSPath(pid, "mnt"))
+		if err != nil {
+			result <- err
+			return
+		}
+		defer targetNS.Close()
+
+		if err := unix.Setns(int(targetNS.Fd()), unix.CLONE_NEWNS); err != nil {
+			result <- fmt.Errorf("failed to enter container mount namespace: %v", err)
+			return
+		}
+		result <- fn()
+	}()
+	return <-result
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Mounts", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mounts")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		HostProc = "/tmp/proc"
+	})
+
+	It("are recorded by container and clean path", func() {
+		mounts := []podconfigv1alpha1.MountSpec{
+			{Target: podconfigv1alpha1.TargetSpec{Container: "cnf"}, HostPath: "/var/lib/cnf", ContainerPath: "/etc/license/"},
+		}
+		Expect(mountName("cnf", "/etc/license/")).To(Equal("cnf:/etc/license"))
+		Expect(containsMount(mounts, "cnf:/etc/license")).To(BeTrue())
+		Expect(containsMount(mounts, "sidecar:/etc/license")).To(BeFalse())
+	})
+
+	It("are found in the mountinfo of the target process", func() {
+		HostProc = dir
+		Expect(os.MkdirAll(filepath.Join(dir, "1234"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "1234", "mountinfo"), []byte(
+			"22 1 253:0 / / rw,relatime - xfs /dev/vda1 rw\n"+
+				"635 22 253:0 /var/lib/cnf /etc/license ro,relatime - xfs /dev/vda1 rw\n"), 0644)).To(Succeed())
+
+		Expect(isMountPoint("1234", "/etc/license/")).To(BeTrue())
+		Expect(isMountPoint("1234", "/etc")).To(BeFalse())
+
+		_, err := isMountPoint("5678", "/etc/license")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("create mount points matching the host path type", func() {
+		Expect(createMountPoint(filepath.Join(dir, "conf", "license"), false)).To(Succeed())
+		Expect(filepath.Join(dir, "conf", "license")).To(BeARegularFile())
+
+		Expect(createMountPoint(filepath.Join(dir, "data"), true)).To(Succeed())
+		Expect(filepath.Join(dir, "data")).To(BeADirectory())
+	})
+
+	It("are left alone on pods that are not running", func() {
+		pod := corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}
+		Expect(unmountAll(pod, []string{"cnf:/etc/license"}, func(string, string, string, ...interface{}) {})).To(Succeed())
+	})
+})
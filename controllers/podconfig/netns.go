@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// HostProc is where the proc file system of the host is mounted in the
+// operator container
+var HostProc = "/tmp/proc"
+
+// Network namespace of the host, the one of its first process
+func hostNetNSPath() string {
+	return procNetNSPath("1")
+}
+
+// Network namespace of a host process
+func procNetNSPath(pid string) string {
+	return procNSPath(pid, "net")
+}
+
+// Namespace of a host process by kind: net, mnt, uts, pid, ...
+func procNSPath(pid string, kind string) string {
+	return filepath.Join(HostProc, pid, "ns", kind)
+}
+
+// podNetNS is the network namespace of a pod. The path is where the
+// namespace is entered, the pid is the one of a process in the pod and the
+// link suffix names the pod links.
+type podNetNS struct {
+	pid        string
+	path       string
+	linkSuffix string
+}
+
+// Pod links are named after the attachment and a hash of the pod uid, 7 hex
+// digits, so that they keep their names when the pod processes restart
+func podLinkSuffix(uid types.UID) string {
+
+	hash := fnv.New32a()
+	hash.Write([]byte(uid))
+	return fmt.Sprintf("%07x", hash.Sum32()&0xfffffff)
+}
@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Network namespace paths", func() {
+
+	AfterEach(func() {
+		HostProc = "/tmp/proc"
+	})
+
+	It("are found under the host proc mount", func() {
+		Expect(hostNetNSPath()).To(Equal("/tmp/proc/1/ns/net"))
+		Expect(procNetNSPath("1234")).To(Equal("/tmp/proc/1234/ns/net"))
+	})
+
+	It("follow a configured host proc mount", func() {
+		HostProc = "/host/proc/"
+		Expect(hostNetNSPath()).To(Equal("/host/proc/1/ns/net"))
+	})
+})
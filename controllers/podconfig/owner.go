@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"strings"
+)
+
+// Host links created by the operator carry an alias starting with this
+// prefix. It tells them apart from links created by administrators.
+const ownerAlias = "podconfig-operator"
+
+// attachmentOwner identifies the pod and podconfig a network attachment
+// was configured for
+type attachmentOwner struct {
+	Namespace string
+	PodConfig string
+	Pod       string
+}
+
+// Alias for the host side of an attachment:
+// podconfig-operator/<namespace>/<podconfig>/<pod>/<attachment>
+func (o attachmentOwner) portAlias(attachment string) string {
+
+	return strings.Join([]string{ownerAlias, o.Namespace, o.PodConfig, o.Pod, attachment}, "/")
+}
+
+// Parses the alias of an attachment host link. Returns false for links
+// not created by the operator for an attachment.
+func parsePortAlias(alias string) (attachmentOwner, string, bool) {
+
+	parts := strings.Split(alias, "/")
+	if len(parts) != 5 || parts[0] != ownerAlias {
+		return attachmentOwner{}, "", false
+	}
+	return attachmentOwner{Namespace: parts[1], PodConfig: parts[2], Pod: parts[3]}, parts[4], true
+}
+
+// Owner of the gateway ip allocated for a bridge
+func bridgeOwner(bridge string) string {
+
+	return ownerAlias + "/" + bridge
+}
+
+// Bridges are shared by attachments of different pods and podconfigs
+// so their alias doesn't carry any owner
+func isOwnedBridge(alias string) bool {
+
+	return alias == ownerAlias
+}
+
+// Whether an attachment owner is selected by o. An empty pod selects the
+// attachments of every pod of the podconfig.
+func (o attachmentOwner) selects(owner attachmentOwner) bool {
+
+	return o.Namespace == owner.Namespace && o.PodConfig == owner.PodConfig &&
+		(o.Pod == "" || o.Pod == owner.Pod)
+}
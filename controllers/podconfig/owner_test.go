@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Attachment owners", func() {
+
+	owner := attachmentOwner{Namespace: "tenant", PodConfig: "pc", Pod: "cnf"}
+
+	It("are recorded in the alias of the host veth", func() {
+		alias := owner.portAlias("net1")
+		Expect(alias).To(Equal("podconfig-operator/tenant/pc/cnf/net1"))
+
+		parsed, attachment, ok := parsePortAlias(alias)
+		Expect(ok).To(BeTrue())
+		Expect(parsed).To(Equal(owner))
+		Expect(attachment).To(Equal("net1"))
+	})
+
+	It("are not found in the alias of other links", func() {
+		for _, alias := range []string{"", "uplink", bridgeOwner("br0"), "other/tenant/pc/cnf/net1"} {
+			_, _, ok := parsePortAlias(alias)
+			Expect(ok).To(BeFalse(), alias)
+		}
+	})
+
+	It("select the attachments of one pod or of the whole podconfig", func() {
+		podConfig := attachmentOwner{Namespace: "tenant", PodConfig: "pc"}
+		other := attachmentOwner{Namespace: "tenant", PodConfig: "pc", Pod: "cnf-b"}
+
+		Expect(owner.selects(owner)).To(BeTrue())
+		Expect(owner.selects(other)).To(BeFalse())
+		Expect(podConfig.selects(owner)).To(BeTrue())
+		Expect(podConfig.selects(other)).To(BeTrue())
+		Expect(podConfig.selects(attachmentOwner{Namespace: "other", PodConfig: "pc", Pod: "cnf"})).To(BeFalse())
+	})
+})
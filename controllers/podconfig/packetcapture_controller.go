@@ -0,0 +1,388 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+)
+
+// PacketCaptureReconciler runs the packet captures of the pods on its node
+type PacketCaptureReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	NodeName string // only pods scheduled to this node are captured when set
+	Recorder record.EventRecorder
+
+	// Captures running at once on the node, and bytes their files may
+	// grow to in total. Captures over the budget wait for others to end.
+	MaxCaptures int
+	ByteBudget  int64
+
+	mutex   sync.Mutex
+	running map[types.NamespacedName]*runningCapture
+}
+
+// runningCapture holds its share of the node budget until it ends
+type runningCapture struct {
+	cancel   context.CancelFunc
+	maxBytes int64
+}
+
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=packetcaptures,verbs=get;list;watch
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=packetcaptures/status,verbs=get;update;patch
+
+// Reconcile function for the PacketCapture instance. Captures run in the
+// background once marked running. Like pod commands, a capture found
+// running after an operator restart is failed instead of run again, and
+// deleting a running capture stops it.
+func (r *PacketCaptureReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+
+	capture := &podconfigv1alpha1.PacketCapture{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, capture)
+	if apierrors.IsNotFound(err) {
+		r.stop(req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if capture.Status.Phase == podconfigv1alpha1.PacketCaptureSucceeded || capture.Status.Phase == podconfigv1alpha1.PacketCaptureFailed {
+		return reconcile.Result{}, nil
+	}
+
+	pod := &corev1.Pod{}
+	err = r.Client.Get(context.TODO(), types.NamespacedName{Namespace: capture.Namespace, Name: capture.Spec.PodName}, pod)
+	if apierrors.IsNotFound(err) {
+		return reconcile.Result{}, r.failMissingPod(capture)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Captures are run by the operator on the node of their pod
+	if r.NodeName != "" && pod.Spec.NodeName != r.NodeName {
+		return reconcile.Result{}, nil
+	}
+
+	if capture.Status.Phase == podconfigv1alpha1.PacketCaptureRunning {
+		if r.isRunning(req.NamespacedName) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, r.fail(capture, pod, "Interrupted by an operator restart, not run again")
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+	case corev1.PodSucceeded, corev1.PodFailed:
+		return reconcile.Result{}, r.fail(capture, pod, "Pod %s is %v", pod.Name, pod.Status.Phase)
+	default:
+		return reconcile.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	// The webhook may have been bypassed or run with other limits
+	maxBytes := capture.MaxBytes()
+	if maxBytes <= 0 || maxBytes > podconfigv1alpha1.MaxCaptureSize.Value() || maxBytes > r.ByteBudget {
+		return reconcile.Result{}, r.fail(capture, pod, "Size limit %d must be positive and at most %d",
+			maxBytes, min64(podconfigv1alpha1.MaxCaptureSize.Value(), r.ByteBudget))
+	}
+
+	iface, err := r.captureInterface(*pod, capture)
+	if err != nil {
+		return reconcile.Result{}, r.fail(capture, pod, "%v", err)
+	}
+	filter, err := compileFilter(capture.Spec.Filter, capture.SnapLength())
+	if err != nil {
+		return reconcile.Result{}, r.fail(capture, pod, "%v", err)
+	}
+	netNS, err := lookupNetNS(*pod)
+	if err != nil {
+		return reconcile.Result{}, r.fail(capture, pod, "Error entering pod network namespace: %v", err)
+	}
+
+	file := capture.Spec.Output.Path
+
+	ctx, cancel := context.WithTimeout(context.Background(), capture.Duration())
+	if !r.reserve(req.NamespacedName, &runningCapture{cancel: cancel, maxBytes: maxBytes}) {
+		cancel()
+		fmt.Printf("Packet capture %s waiting for running captures to end\n", req.NamespacedName)
+		return reconcile.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	// Another operator instance or an older copy of the object fails here
+	// with a conflict before anything is captured
+	now := metav1.Now()
+	capture.Status.Phase = podconfigv1alpha1.PacketCaptureRunning
+	capture.Status.Node = pod.Spec.NodeName
+	capture.Status.Interface = iface
+	if iface == "" {
+		capture.Status.Interface = "any"
+	}
+	capture.Status.StartTime = &now
+	capture.Status.File = file
+	if err := r.Client.Status().Update(context.TODO(), capture); err != nil {
+		r.release(req.NamespacedName)
+		return reconcile.Result{}, err
+	}
+
+	fd, err := openCaptureSocket(netNS.path, iface, filter)
+	if err != nil {
+		r.release(req.NamespacedName)
+		return reconcile.Result{}, r.fail(capture, pod, "Error opening capture socket: %v", err)
+	}
+	out, err := r.createOutput(*pod, capture)
+	if err != nil {
+		unix.Close(fd)
+		r.release(req.NamespacedName)
+		return reconcile.Result{}, r.fail(capture, pod, "Error creating %s: %v", file, err)
+	}
+
+	event := requestEvents(r.Recorder, capture, "Packet capture", capture.Name, pod)
+	event(corev1.EventTypeNormal, reasonCaptureStarted, "Capturing on %s of pod %s on node %s for %v",
+		capture.Status.Interface, pod.Name, pod.Spec.NodeName, capture.Duration())
+
+	go func() {
+		defer r.release(req.NamespacedName)
+
+		result, err := runCapture(ctx, fd, out, capture.SnapLength(), maxBytes)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+
+		phase := podconfigv1alpha1.PacketCaptureSucceeded
+		message := result.Reason
+		if err != nil {
+			phase = podconfigv1alpha1.PacketCaptureFailed
+			message = err.Error()
+		}
+
+		updateErr := r.updateStatus(capture, func(status *podconfigv1alpha1.PacketCaptureStatus) {
+			completion := metav1.Now()
+			status.Phase = phase
+			status.Message = message
+			status.CompletionTime = &completion
+			status.Packets = result.Packets
+			status.Dropped = result.Dropped
+			status.Bytes = result.Bytes
+		})
+		if apierrors.IsNotFound(updateErr) {
+			// Deleted while running
+			return
+		}
+		if updateErr != nil {
+			fmt.Printf("Error updating packet capture %s/%s: %v\n", capture.Namespace, capture.Name, updateErr)
+		}
+
+		if phase == podconfigv1alpha1.PacketCaptureSucceeded {
+			event(corev1.EventTypeNormal, reasonCaptureCompleted, "Captured %d packets to %s, %s",
+				result.Packets, file, message)
+		} else {
+			event(corev1.EventTypeWarning, reasonCaptureFailed, "Capture failed after %d packets: %s", result.Packets, message)
+		}
+	}()
+
+	return reconcile.Result{}, nil
+}
+
+// Name of the pod interface of the capture attachment, empty for all
+// interfaces of the pod
+func (r *PacketCaptureReconciler) captureInterface(pod corev1.Pod, capture *podconfigv1alpha1.PacketCapture) (string, error) {
+
+	attachment := capture.Spec.Attachment
+	if attachment == nil {
+		return "", nil
+	}
+
+	podConfig := &podconfigv1alpha1.PodConfig{}
+	err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: capture.Namespace, Name: attachment.PodConfig}, podConfig)
+	if err != nil {
+		return "", fmt.Errorf("Error getting podconfig %s: %v", attachment.PodConfig, err)
+	}
+	for _, configuration := range podConfig.Status.PodConfigurations {
+		if configuration.PodName != pod.Name {
+			continue
+		}
+		for _, iface := range configuration.Interfaces {
+			if iface.Attachment == attachment.Name {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Attachment %s of podconfig %s is not configured on pod %s", attachment.Name, attachment.PodConfig, pod.Name)
+}
+
+// Creates the pcap file in the output container. Existing files, symbolic
+// links included, are never overwritten.
+func (r *PacketCaptureReconciler) createOutput(pod corev1.Pod, capture *podconfigv1alpha1.PacketCapture) (*os.File, error) {
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+
+	pid, err := commandTarget(pod, capture.Spec.Output.Container)
+	if err != nil {
+		return nil, err
+	}
+	var file *os.File
+	err = doInMountNS(pid, func() (err error) {
+		file, err = os.OpenFile(capture.Spec.Output.Path, flags, 0644)
+		return err
+	})
+	return file, err
+}
+
+func (r *PacketCaptureReconciler) isRunning(name types.NamespacedName) bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, ok := r.running[name]
+	return ok
+}
+
+// Takes a share of the node budget for a capture, false when the running
+// captures leave too little of it
+func (r *PacketCaptureReconciler) reserve(name types.NamespacedName, capture *runningCapture) bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.running == nil {
+		r.running = map[types.NamespacedName]*runningCapture{}
+	}
+	reserved := capture.maxBytes
+	for _, running := range r.running {
+		reserved += running.maxBytes
+	}
+	if len(r.running) >= r.MaxCaptures || reserved > r.ByteBudget {
+		return false
+	}
+	r.running[name] = capture
+	return true
+}
+
+// Gives the share of an ended capture back to the node budget
+func (r *PacketCaptureReconciler) release(name types.NamespacedName) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if running, ok := r.running[name]; ok {
+		running.cancel()
+		delete(r.running, name)
+	}
+}
+
+// Stops a running capture, which then completes with what it captured and
+// releases its share of the budget
+func (r *PacketCaptureReconciler) stop(name types.NamespacedName) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if running, ok := r.running[name]; ok {
+		running.cancel()
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Fails a packet capture that can't be run
+func (r *PacketCaptureReconciler) fail(capture *podconfigv1alpha1.PacketCapture, pod *corev1.Pod, messageFmt string, args ...interface{}) error {
+
+	message := fmt.Sprintf(messageFmt, args...)
+	requestEvents(r.Recorder, capture, "Packet capture", capture.Name, pod)(corev1.EventTypeWarning, reasonCaptureFailed, "%s", message)
+
+	return r.updateStatus(capture, func(status *podconfigv1alpha1.PacketCaptureStatus) {
+		completion := metav1.Now()
+		status.Phase = podconfigv1alpha1.PacketCaptureFailed
+		status.Message = message
+		status.CompletionTime = &completion
+	})
+}
+
+// Fails a capture whose pod is gone, and with it the node of the pod. A
+// capture that started is failed by the operator of its node, one that
+// never started by the first operator whose status update goes through.
+func (r *PacketCaptureReconciler) failMissingPod(capture *podconfigv1alpha1.PacketCapture) error {
+
+	message := fmt.Sprintf("Pod %s not found", capture.Spec.PodName)
+
+	if capture.Status.Node != "" {
+		if r.NodeName != "" && capture.Status.Node != r.NodeName {
+			return nil
+		}
+		return r.fail(capture, nil, "%s", message)
+	}
+
+	completion := metav1.Now()
+	capture.Status.Phase = podconfigv1alpha1.PacketCaptureFailed
+	capture.Status.Message = message
+	capture.Status.CompletionTime = &completion
+	err := r.Client.Status().Update(context.TODO(), capture)
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	requestEvents(r.Recorder, capture, "Packet capture", capture.Name, nil)(corev1.EventTypeWarning, reasonCaptureFailed, "%s", message)
+	return nil
+}
+
+// The outcome of a capture is written even when the object changed meanwhile
+func (r *PacketCaptureReconciler) updateStatus(capture *podconfigv1alpha1.PacketCapture, update func(*podconfigv1alpha1.PacketCaptureStatus)) error {
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &podconfigv1alpha1.PacketCapture{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: capture.Namespace, Name: capture.Name}, latest)
+		if err != nil {
+			return err
+		}
+		update(&latest.Status)
+		return r.Client.Status().Update(context.TODO(), latest)
+	})
+}
+
+// SetupWithManager for the packet capture controller
+func (r *PacketCaptureReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&podconfigv1alpha1.PacketCapture{}).
+		Complete(r)
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Packet captures", func() {
+
+	Describe("node budget", func() {
+
+		var (
+			r        *PacketCaptureReconciler
+			canceled []string
+		)
+
+		newCapture := func(name string, maxBytes int64) (types.NamespacedName, *runningCapture) {
+			return types.NamespacedName{Namespace: "tenant", Name: name}, &runningCapture{
+				cancel:   func() { canceled = append(canceled, name) },
+				maxBytes: maxBytes,
+			}
+		}
+
+		BeforeEach(func() {
+			r = &PacketCaptureReconciler{MaxCaptures: 2, ByteBudget: 100}
+			canceled = nil
+		})
+
+		It("bounds the captures running at once", func() {
+			Expect(r.reserve(newCapture("a", 10))).To(BeTrue())
+			Expect(r.reserve(newCapture("b", 10))).To(BeTrue())
+			Expect(r.reserve(newCapture("c", 10))).To(BeFalse())
+
+			r.release(types.NamespacedName{Namespace: "tenant", Name: "a"})
+			Expect(canceled).To(Equal([]string{"a"}))
+			Expect(r.reserve(newCapture("c", 10))).To(BeTrue())
+		})
+
+		It("bounds the total size of the running captures", func() {
+			Expect(r.reserve(newCapture("a", 60))).To(BeTrue())
+			Expect(r.reserve(newCapture("b", 50))).To(BeFalse())
+			Expect(r.reserve(newCapture("b", 40))).To(BeTrue())
+		})
+
+		It("keeps the share of a stopped capture until it is released", func() {
+			name, capture := newCapture("a", 60)
+			Expect(r.reserve(name, capture)).To(BeTrue())
+
+			r.stop(name)
+			Expect(canceled).To(Equal([]string{"a"}))
+			Expect(r.isRunning(name)).To(BeTrue())
+			Expect(r.reserve(newCapture("b", 50))).To(BeFalse())
+
+			r.release(name)
+			Expect(r.isRunning(name)).To(BeFalse())
+		})
+	})
+
+	Describe("of missing pods", func() {
+
+		var s *runtime.Scheme
+
+		BeforeEach(func() {
+			s = runtime.NewScheme()
+			Expect(scheme.AddToScheme(s)).To(Succeed())
+			Expect(podconfigv1alpha1.AddToScheme(s)).To(Succeed())
+		})
+
+		reconcileCapture := func(nodeName string, status podconfigv1alpha1.PacketCaptureStatus) *podconfigv1alpha1.PacketCapture {
+			capture := &podconfigv1alpha1.PacketCapture{
+				ObjectMeta: metav1.ObjectMeta{Name: "icmp", Namespace: "tenant"},
+				Spec: podconfigv1alpha1.PacketCaptureSpec{
+					PodName: "cnf",
+					Output:  podconfigv1alpha1.CaptureOutput{Container: "cnf", Path: "/data/capture.pcap"},
+				},
+				Status: status,
+			}
+			r := &PacketCaptureReconciler{NodeName: nodeName, Client: fake.NewFakeClientWithScheme(s, capture)}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "tenant", Name: "icmp"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			updated := &podconfigv1alpha1.PacketCapture{}
+			Expect(r.Client.Get(context.TODO(), types.NamespacedName{Namespace: "tenant", Name: "icmp"}, updated)).To(Succeed())
+			return updated
+		}
+
+		It("are failed by any node when the capture never started", func() {
+			capture := reconcileCapture("node1", podconfigv1alpha1.PacketCaptureStatus{})
+			Expect(capture.Status.Phase).To(Equal(podconfigv1alpha1.PacketCaptureFailed))
+			Expect(capture.Status.Message).To(Equal("Pod cnf not found"))
+		})
+
+		It("are failed by the node the capture started on", func() {
+			running := podconfigv1alpha1.PacketCaptureStatus{Phase: podconfigv1alpha1.PacketCaptureRunning, Node: "node1"}
+
+			Expect(reconcileCapture("node2", running).Status.Phase).To(Equal(podconfigv1alpha1.PacketCaptureRunning))
+			Expect(reconcileCapture("node1", running).Status.Phase).To(Equal(podconfigv1alpha1.PacketCaptureFailed))
+		})
+	})
+})
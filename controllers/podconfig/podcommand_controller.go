@@ -0,0 +1,354 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+)
+
+// Bytes of stdout and stderr kept in the pod command status
+const commandOutputLimit = 4096
+
+// Time given to the output of a killed command to be closed
+const commandWaitDelay = 5 * time.Second
+
+// PodCommandReconciler runs the pod commands of the pods on its node
+type PodCommandReconciler struct {
+	client.Client
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	NodeName      string        // only commands for pods scheduled to this node are run when set
+	ClusterReader client.Reader // reads cluster scoped objects, defaults to the client
+	Recorder      record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podcommands,verbs=get;list;watch
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podcommands/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podcommandtemplates,verbs=get;list;watch
+
+// Reconcile function for the PodCommand instance. A command is run at most
+// once: it is marked running before it starts and a command found running
+// again, after an operator restart, is failed instead of run twice.
+func (r *PodCommandReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+
+	podCommand := &podconfigv1alpha1.PodCommand{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, podCommand)
+	if apierrors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if podCommand.Status.Phase == podconfigv1alpha1.PodCommandSucceeded || podCommand.Status.Phase == podconfigv1alpha1.PodCommandFailed {
+		return reconcile.Result{}, nil
+	}
+
+	pod := &corev1.Pod{}
+	err = r.Client.Get(context.TODO(), types.NamespacedName{Namespace: podCommand.Namespace, Name: podCommand.Spec.PodName}, pod)
+	if apierrors.IsNotFound(err) {
+		return reconcile.Result{}, r.failMissingPod(podCommand)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Commands are run by the operator on the node of their pod
+	if r.NodeName != "" && pod.Spec.NodeName != r.NodeName {
+		return reconcile.Result{}, nil
+	}
+
+	if podCommand.Status.Phase == podconfigv1alpha1.PodCommandRunning {
+		return reconcile.Result{}, r.fail(podCommand, pod, "Interrupted by an operator restart, not run again")
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+	case corev1.PodSucceeded, corev1.PodFailed:
+		return reconcile.Result{}, r.fail(podCommand, pod, "Pod %s is %v", pod.Name, pod.Status.Phase)
+	default:
+		// Pod updates don't trigger pod commands
+		return reconcile.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	// Templates may have changed since the command was admitted
+	clusterReader := r.ClusterReader
+	if clusterReader == nil {
+		clusterReader = r.Client
+	}
+	template, violations, err := podconfigv1alpha1.TemplateViolations(context.TODO(), clusterReader, podCommand)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(violations) > 0 {
+		return reconcile.Result{}, r.fail(podCommand, pod, "Not allowed: %v", violations.ToAggregate())
+	}
+
+	argv, err := commandLine(*pod, podCommand, template)
+	if err != nil {
+		return reconcile.Result{}, r.fail(podCommand, pod, "Error entering pod namespaces: %v", err)
+	}
+
+	// Another operator instance or an older copy of the object fails here
+	// with a conflict before anything is run
+	now := metav1.Now()
+	podCommand.Status.Phase = podconfigv1alpha1.PodCommandRunning
+	podCommand.Status.Node = pod.Spec.NodeName
+	podCommand.Status.Command = argv
+	podCommand.Status.StartTime = &now
+	if err := r.Client.Status().Update(context.TODO(), podCommand); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	event := requestEvents(r.Recorder, podCommand, "Pod command", podCommand.Name, pod)
+	event(corev1.EventTypeNormal, reasonCommandStarted, "Running template %s on node %s: %s",
+		template.Name, pod.Spec.NodeName, strings.Join(argv, " "))
+
+	timeout := template.Timeout(podCommand)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdout := &tailBuffer{limit: commandOutputLimit}
+	stderr := &tailBuffer{limit: commandOutputLimit}
+	exitCode, runErr := runCommand(ctx, argv, stdout, stderr)
+
+	phase := podconfigv1alpha1.PodCommandSucceeded
+	message := ""
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		phase = podconfigv1alpha1.PodCommandFailed
+		message = fmt.Sprintf("Timed out after %v", timeout)
+	case runErr != nil:
+		phase = podconfigv1alpha1.PodCommandFailed
+		message = runErr.Error()
+	}
+
+	err = r.updateStatus(podCommand, func(status *podconfigv1alpha1.PodCommandStatus) {
+		completion := metav1.Now()
+		status.Phase = phase
+		status.Message = message
+		status.CompletionTime = &completion
+		status.ExitCode = &exitCode
+		status.Stdout = stdout.String()
+		status.Stderr = stderr.String()
+	})
+
+	// Audit trail of every run in the operator log, along with the events
+	fmt.Printf("Audit: pod command %s/%s template %s pod %s node %s command %q exit code %d: %v\n",
+		podCommand.Namespace, podCommand.Name, template.Name, pod.Name, pod.Spec.NodeName, argv, exitCode, phase)
+
+	if phase == podconfigv1alpha1.PodCommandSucceeded {
+		event(corev1.EventTypeNormal, reasonCommandSucceeded, "Command exited with code %d", exitCode)
+	} else {
+		event(corev1.EventTypeWarning, reasonCommandFailed, "Command failed with exit code %d: %s", exitCode, message)
+	}
+	return reconcile.Result{}, err
+}
+
+// Runs a command until it exits or the context is done. The command gets
+// its own process group, killed as a whole on timeout so the children of a
+// command forked into a pid namespace don't outlive it, and is killed along
+// with the operator.
+func runCommand(ctx context.Context, argv []string, stdout, stderr io.Writer) (int32, error) {
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Output pipes held open by a process that escaped the group
+	cmd.WaitDelay = commandWaitDelay
+	err := cmd.Run()
+
+	exitCode := int32(-1)
+	if cmd.ProcessState != nil {
+		exitCode = int32(cmd.ProcessState.ExitCode())
+	}
+	return exitCode, err
+}
+
+// Builds the nsenter command line entering the namespaces of the template.
+// The net namespace is the one of the pod sandbox, the others are the ones
+// of the target container.
+func commandLine(pod corev1.Pod, podCommand *podconfigv1alpha1.PodCommand, template *podconfigv1alpha1.PodCommandTemplate) ([]string, error) {
+
+	nsenterFlags := map[podconfigv1alpha1.CommandNamespace]string{
+		podconfigv1alpha1.CommandNamespaceNet: "--net",
+		podconfigv1alpha1.CommandNamespaceUTS: "--uts",
+		podconfigv1alpha1.CommandNamespaceIPC: "--ipc",
+		podconfigv1alpha1.CommandNamespacePID: "--pid",
+	}
+
+	argv := []string{"nsenter"}
+	containerPid := ""
+	for _, namespace := range template.EnteredNamespaces() {
+
+		if _, ok := nsenterFlags[namespace]; !ok {
+			return nil, fmt.Errorf("the %s namespace can't be entered", namespace)
+		}
+
+		if namespace == podconfigv1alpha1.CommandNamespaceNet {
+			netNS, err := lookupNetNS(pod)
+			if err != nil {
+				return nil, err
+			}
+			argv = append(argv, "--net="+netNS.path)
+			continue
+		}
+
+		if containerPid == "" {
+			pid, err := commandTarget(pod, podCommand.Spec.Container)
+			if err != nil {
+				return nil, err
+			}
+			containerPid = pid
+		}
+		argv = append(argv, nsenterFlags[namespace]+"="+procNSPath(containerPid, string(namespace)))
+	}
+
+	argv = append(argv, "--")
+	argv = append(argv, template.Spec.Command...)
+	return append(argv, podCommand.Spec.Args...), nil
+}
+
+// Pid of the named container, or of the first running one
+func commandTarget(pod corev1.Pod, container string) (string, error) {
+
+	if container == "" {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.State.Running != nil {
+				container = containerStatus.Name
+				break
+			}
+		}
+	}
+
+	conn, err := getCRIOConnection()
+	if err != nil {
+		return "", fmt.Errorf("Error getting CRIO connection: %v", err)
+	}
+	defer conn.Close()
+
+	return resolveTarget(pod, podconfigv1alpha1.TargetSpec{Container: container}, conn)
+}
+
+// Fails a pod command that can't be run
+func (r *PodCommandReconciler) fail(podCommand *podconfigv1alpha1.PodCommand, pod *corev1.Pod, messageFmt string, args ...interface{}) error {
+
+	message := fmt.Sprintf(messageFmt, args...)
+	requestEvents(r.Recorder, podCommand, "Pod command", podCommand.Name, pod)(corev1.EventTypeWarning, reasonCommandFailed, "%s", message)
+
+	return r.updateStatus(podCommand, func(status *podconfigv1alpha1.PodCommandStatus) {
+		completion := metav1.Now()
+		status.Phase = podconfigv1alpha1.PodCommandFailed
+		status.Message = message
+		status.CompletionTime = &completion
+	})
+}
+
+// Fails a pod command whose pod is gone, and with it the node of the pod.
+// A command that started is failed by the operator of its node, one that
+// never started by the first operator whose status update goes through.
+func (r *PodCommandReconciler) failMissingPod(podCommand *podconfigv1alpha1.PodCommand) error {
+
+	message := fmt.Sprintf("Pod %s not found", podCommand.Spec.PodName)
+
+	if podCommand.Status.Node != "" {
+		if r.NodeName != "" && podCommand.Status.Node != r.NodeName {
+			return nil
+		}
+		return r.fail(podCommand, nil, "%s", message)
+	}
+
+	completion := metav1.Now()
+	podCommand.Status.Phase = podconfigv1alpha1.PodCommandFailed
+	podCommand.Status.Message = message
+	podCommand.Status.CompletionTime = &completion
+	err := r.Client.Status().Update(context.TODO(), podCommand)
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	requestEvents(r.Recorder, podCommand, "Pod command", podCommand.Name, nil)(corev1.EventTypeWarning, reasonCommandFailed, "%s", message)
+	return nil
+}
+
+// The outcome of a run is written even when the object changed meanwhile
+func (r *PodCommandReconciler) updateStatus(podCommand *podconfigv1alpha1.PodCommand, update func(*podconfigv1alpha1.PodCommandStatus)) error {
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &podconfigv1alpha1.PodCommand{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: podCommand.Namespace, Name: podCommand.Name}, latest)
+		if err != nil {
+			return err
+		}
+		update(&latest.Status)
+		return r.Client.Status().Update(context.TODO(), latest)
+	})
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf.Write(p)
+	if extra := t.buf.Len() - t.limit; extra > 0 {
+		t.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return t.buf.String()
+}
+
+// SetupWithManager for the pod command controller. Commands are run from
+// the reconcile, a few at a time so a long one doesn't hold the others.
+func (r *PodCommandReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&podconfigv1alpha1.PodCommand{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 4}).
+		Complete(r)
+}
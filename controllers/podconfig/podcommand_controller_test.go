@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Pod commands", func() {
+
+	Describe("run", func() {
+
+		It("report the exit code and output of the command", func() {
+			stdout := &tailBuffer{limit: commandOutputLimit}
+			stderr := &tailBuffer{limit: commandOutputLimit}
+
+			exitCode, err := runCommand(context.Background(), []string{"sh", "-c", "echo up; echo down >&2; exit 3"}, stdout, stderr)
+			Expect(err).To(HaveOccurred())
+			Expect(exitCode).To(Equal(int32(3)))
+			Expect(stdout.String()).To(Equal("up\n"))
+			Expect(stderr.String()).To(Equal("down\n"))
+		})
+
+		It("kill the whole process group on timeout", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			// The child holds the output open, it has to be killed too
+			// for the run to end before the wait delay
+			start := time.Now()
+			_, err := runCommand(ctx, []string{"sh", "-c", "sleep 30 & wait"}, &tailBuffer{limit: 16}, &tailBuffer{limit: 16})
+			Expect(err).To(HaveOccurred())
+			Expect(ctx.Err()).To(Equal(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", commandWaitDelay))
+		})
+
+		It("keep the tail of long output", func() {
+			tail := &tailBuffer{limit: 4}
+			tail.Write([]byte("abc"))
+			tail.Write([]byte("defg"))
+			Expect(tail.String()).To(Equal("defg"))
+		})
+	})
+
+	Describe("of missing pods", func() {
+
+		var s *runtime.Scheme
+
+		BeforeEach(func() {
+			s = runtime.NewScheme()
+			Expect(scheme.AddToScheme(s)).To(Succeed())
+			Expect(podconfigv1alpha1.AddToScheme(s)).To(Succeed())
+		})
+
+		reconcileCommand := func(nodeName string, status podconfigv1alpha1.PodCommandStatus) *podconfigv1alpha1.PodCommand {
+			podCommand := &podconfigv1alpha1.PodCommand{
+				ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "tenant"},
+				Spec:       podconfigv1alpha1.PodCommandSpec{Template: "ping", PodName: "cnf"},
+				Status:     status,
+			}
+			r := &PodCommandReconciler{NodeName: nodeName, Client: fake.NewFakeClientWithScheme(s, podCommand)}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "tenant", Name: "ping"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			updated := &podconfigv1alpha1.PodCommand{}
+			Expect(r.Client.Get(context.TODO(), types.NamespacedName{Namespace: "tenant", Name: "ping"}, updated)).To(Succeed())
+			return updated
+		}
+
+		It("are failed by any node when the command never started", func() {
+			podCommand := reconcileCommand("node1", podconfigv1alpha1.PodCommandStatus{})
+			Expect(podCommand.Status.Phase).To(Equal(podconfigv1alpha1.PodCommandFailed))
+			Expect(podCommand.Status.Message).To(Equal("Pod cnf not found"))
+			Expect(podCommand.Status.CompletionTime).NotTo(BeNil())
+		})
+
+		It("are failed by the node the command started on", func() {
+			running := podconfigv1alpha1.PodCommandStatus{Phase: podconfigv1alpha1.PodCommandRunning, Node: "node1"}
+
+			Expect(reconcileCommand("node2", running).Status.Phase).To(Equal(podconfigv1alpha1.PodCommandRunning))
+			Expect(reconcileCommand("node1", running).Status.Phase).To(Equal(podconfigv1alpha1.PodCommandFailed))
+		})
+	})
+})
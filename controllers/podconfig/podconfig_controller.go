@@ -19,14 +19,23 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
 )
@@ -34,170 +43,560 @@ import (
 // PodConfigReconciler reconciles a PodConfig object
 type PodConfigReconciler struct {
 	client.Client
-	Log           logr.Logger
-	Scheme        *runtime.Scheme
-	podConfigList *podconfigv1alpha1.PodConfigList
-	// podList       *corev1.PodList // TODO: needs a struct with a podlist for each podconfig
+	Log            logr.Logger
+	Scheme         *runtime.Scheme
+	NodeName       string        // only pods scheduled to this node are configured when set
+	ClusterReader  client.Reader // reads cluster scoped objects, defaults to the client
+	Recorder       record.EventRecorder
+	ResyncInterval time.Duration // configured pods are checked for drift this often, zero disables it
+
+	// Podconfigs whose links changed on the node, see LinkWatcher
+	LinkEvents <-chan event.GenericEvent
 }
 
-// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigs,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigs/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=apps,resources=daemonsets;deployments;deployments/finalizers;replicasets,verbs=get;list;watch;create;update;patch;delete,namespace=cnf-test
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigs/finalizers,verbs=update
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create
+
+// Reconcile function for the PodConfig instance. Missing permissions are
+// reported in the Authorized condition and retried once a minute since
+// RBAC changes don't trigger any reconcile.
+func (r *PodConfigReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
-// +kubebuilder:rbac:groups="*",resources="*",verbs="*"
+	result, err := r.reconcile(req)
+	if err != nil && isForbidden(err) {
+		fmt.Printf("Missing permission reconciling %v: %v\n", req.NamespacedName, err)
+		failures.WithLabelValues("Forbidden").Inc()
+		r.setAuthorized(req, corev1.ConditionFalse, "Forbidden", err.Error())
+		return reconcile.Result{RequeueAfter: time.Minute}, nil
+	}
+	if err == nil {
+		r.setAuthorized(req, corev1.ConditionTrue, "Authorized", "")
+	}
+	return result, err
+}
 
-// Reconcile function for the PodConfig instance
-func (r *PodConfigReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
-	reqLogger := r.Log.WithName("podconfig-operator").WithValues("podconfig", req.NamespacedName)
+// Records the Authorized condition, a failure is only logged since the
+// status itself may be what the operator isn't allowed to update
+func (r *PodConfigReconciler) setAuthorized(req ctrl.Request, status corev1.ConditionStatus, reason, message string) {
 
-	// List all pod configuration objects present on the namespace
-	r.podConfigList = &podconfigv1alpha1.PodConfigList{}
-	err := r.Client.List(context.TODO(), r.podConfigList)
+	podConfig := &podconfigv1alpha1.PodConfig{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, podConfig)
 	if err != nil {
-		return reconcile.Result{}, err
+		return
+	}
+	if setCondition(&podConfig.Status, podconfigv1alpha1.PodConfigAuthorized, status, reason, message) {
+		if err := r.Client.Status().Update(context.TODO(), podConfig); err != nil {
+			fmt.Printf("Error updating authorized condition of %v: %v\n", req.NamespacedName, err)
+		}
 	}
+}
+
+func (r *PodConfigReconciler) reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	_ = context.Background()
+	reqLogger := r.Log.WithName("podconfig-operator").WithValues("podconfig", req.NamespacedName)
 
-	if len(r.podConfigList.Items) <= 0 {
+	// Only the requested pod configuration is reconciled, the others get their own requests
+	podConfig := podconfigv1alpha1.PodConfig{}
+	err = r.Client.Get(context.TODO(), req.NamespacedName, &podConfig)
+	if errors.IsNotFound(err) {
+		r.deleteMetrics(req)
 		return reconcile.Result{}, nil
 	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 	// TODO: Update the status field with conditions while creating the new instance
 
-	for _, podConfig := range r.podConfigList.Items {
+	finalizer := "podconfig.finalizers.opdev.io"
 
-		finalizer := "podconfig.finalizers.opdev.io"
+	// examine DeletionTimestamp to determine if podConfig is under deletion
+	if podConfig.ObjectMeta.DeletionTimestamp.IsZero() {
 
-		// examine DeletionTimestamp to determine if podConfig is under deletion
-		if podConfig.ObjectMeta.DeletionTimestamp.IsZero() {
+		// podConfig is not being deleted, so if it does not have our finalizer,
+		// then lets add the finalizer and update the object. This is equivalent
+		// registering our finalizer.
+
+		if !containsString(podConfig.GetFinalizers(), finalizer) {
+			podConfig.SetFinalizers(append(podConfig.GetFinalizers(), finalizer))
+			if err := r.Update(context.Background(), &podConfig); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	} else {
+		// podConfig is being deleted
+		if containsString(podConfig.GetFinalizers(), finalizer) {
+
+			// Mounts and file capabilities are in the containers of
+			// every node, the finalizer stays until each node reverted
+			// the ones of its pods
+			if err := r.revertPods(&podConfig); err != nil {
+				return reconcile.Result{}, err
+			}
+			pending, err := r.pendingReverts(&podConfig)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			if len(pending) > 0 {
+				fmt.Printf("Waiting for pods %v to be reverted by their node\n", pending)
+				return reconcile.Result{RequeueAfter: 5 * time.Second}, nil
+			}
 
-			// podConfig is not being deleted, so if it does not have our finalizer,
-			// then lets add the finalizer and update the object. This is equivalent
-			// registering our finalizer.
+			// finalizer is present, delete configurations from the host side
+			// so that pods already gone don't block the deletion. Nodes
+			// that don't get to it before the finalizer is removed are
+			// cleaned up by their sweeper.
+			owner := attachmentOwner{Namespace: podConfig.ObjectMeta.Namespace, PodConfig: podConfig.ObjectMeta.Name}
+			if err := cleanupHostState(owner, recordedBridges(podConfig, ""), podEvents(r.Recorder, &podConfig, nil)); err != nil {
+				// if fail to delete the external dependency here, return with error
+				// so that it can be retried
+				return reconcile.Result{}, err
+			}
 
-			if !containsString(podConfig.GetFinalizers(), finalizer) {
-				podConfig.SetFinalizers(append(podConfig.GetFinalizers(), finalizer))
-				if err := r.Update(context.Background(), &podConfig); err != nil {
+			// Pods of every node are released, their nodes clean up on sweep
+			podList := &corev1.PodList{}
+			err = r.Client.List(context.TODO(), podList, client.InNamespace(podConfig.ObjectMeta.Namespace),
+				client.MatchingLabels{"podconfig": podConfig.ObjectMeta.Name})
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			for i := range podList.Items {
+				if err := r.removePodFinalizer(&podList.Items[i]); err != nil {
 					return reconcile.Result{}, err
 				}
 			}
-		} else {
-			// podConfig is being deleted
-			if containsString(podConfig.GetFinalizers(), finalizer) {
 
-				// finalizer is present, delete configurations
+			// remove our finalizer from the list and update it.
+			podConfig.SetFinalizers(removeString(podConfig.GetFinalizers(), finalizer))
+			if err := r.Update(context.Background(), &podConfig); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
 
-				// Get the pods with matching labels to podConfig
-				podList, err := r.listPodsWithMatchingLabels(podConfig)
-				if err != nil {
-					return reconcile.Result{}, err
-				}
-				// Delete configuration defined in the podconfig CR from pods with the appropriate label.
-				for _, pod := range podList.Items {
-
-					if err := deleteConfig(pod, &podConfig); err != nil {
-						// if fail to delete the external dependency here, return with error
-						// so that it can be retried
-						return reconcile.Result{}, err
-					}
-				}
+		// Stop reconciliation as the item is being deleted
+		r.deleteMetrics(req)
+		return ctrl.Result{}, nil
+	}
 
-				// remove our finalizer from the list and update it.
-				podConfig.SetFinalizers(removeString(podConfig.GetFinalizers(), finalizer))
-				if err := r.Update(context.Background(), &podConfig); err != nil {
-					return reconcile.Result{}, err
-				}
+	// Deleted pods are cleaned up before the remaining ones are configured,
+	// whether the podconfig is still admitted or not
+	if err := r.cleanupGonePods(&podConfig); err != nil {
+		return reconcile.Result{}, err
+	}
+	teardownRetry, err := r.teardownPods(&podConfig)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if teardownRetry > 0 {
+		defer func() {
+			if result.RequeueAfter == 0 || teardownRetry < result.RequeueAfter {
+				result.RequeueAfter = teardownRetry
 			}
+		}()
+	}
 
-			// Stop reconciliation as the item is being deleted
-			return ctrl.Result{}, nil
+	// Podconfigs admitted before a policy was created or changed are
+	// held back until they comply with it again
+	admitted, err := r.checkPolicies(&podConfig)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !admitted {
+		return reconcile.Result{}, nil
+	}
+
+	// Pods already configured keep their configuration over quota,
+	// new pods wait until the namespace is back within its quotas
+	withinQuota, err := r.checkQuota(&podConfig)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if podConfig.Spec.SampleDeployment.Create {
+
+		// Creates test deployments to PoC pod-to-pod communication over On demmand created Linux Veth Pairs
+
+		err := r.createSampleDeployment(&podConfig, podConfig.Spec.SampleDeployment.Name, podConfig.ObjectMeta.Namespace, map[string]string{"podconfig": podConfig.ObjectMeta.Name})
+		if err != nil {
+			reqLogger.Error(err, "Failed to reconcile resource", "Name", podConfig.Spec.SampleDeployment.Name, "Namespace", podConfig.ObjectMeta.Namespace)
+			return reconcile.Result{}, err
 		}
+	}
 
-		if podConfig.Spec.SampleDeployment.Create {
+	podList, err := r.listPodsWithMatchingLabels(podConfig)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// Apply configuration defined in the podconfig CR to pods with the appropriate label.
+	configured := 0
+	notReady := false
+	for _, pod := range podList.Items {
+
+		// Pods need to be running in order to receive new configuration
+		// Wait for pod phase running
+		event := podEvents(r.Recorder, &podConfig, &pod)
+		if !pod.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if pod.Status.Phase != "Running" {
+			event(corev1.EventTypeNormal, reasonPodSkipped, "Pod phase is %v, waiting for it to run", pod.Status.Phase)
+			return reconcile.Result{}, nil
+		}
 
-			// Creates test deployments to PoC pod-to-pod communication over On demmand created Linux Veth Pairs
+		if !withinQuota && !isPodConfigured(podConfig, pod.ObjectMeta.Name) {
+			event(corev1.EventTypeWarning, reasonQuotaExceeded, "Namespace %v is over its podconfig quota, pod not configured", pod.ObjectMeta.Namespace)
+			continue
+		}
 
-			err := r.createSampleDeployment(&podConfig, podConfig.Spec.SampleDeployment.Name, podConfig.ObjectMeta.Namespace, map[string]string{"podconfig": podConfig.ObjectMeta.Name})
-			if err != nil {
-				reqLogger.Error(err, "Failed to reconcile resource", "Name", "cnf-example", "Namespace", "cnf-test")
+		// The finalizer is there before the pod carries any configuration
+		if wantsPodFinalizer(podConfig) {
+			if err := r.addPodFinalizer(&pod); err != nil {
 				return reconcile.Result{}, err
 			}
 		}
 
-		podList, err := r.listPodsWithMatchingLabels(podConfig)
+		configStatus, podDrift, err := applyConfig(pod, &podConfig, event)
+		if isSandboxNotReady(err) {
+			notReady = true
+			continue
+		}
 		if err != nil {
+			fmt.Printf("%v", err)
 			return reconcile.Result{}, err
 		}
-		// Apply configuration defined in the podconfig CR to pods with the appropriate label.
-		for _, pod := range podList.Items {
+		fmt.Printf("%v", configStatus.ConfigList)
+		configured++
+		configStatus.Drift = podDrift
 
-			// Pods need to be running in order to receive new configuration
-			// Wait for pod phase running
-			if pod.Status.Phase != "Running" {
-				fmt.Printf("pod %v phase is %v, requeuing... ", pod.ObjectMeta.Name, pod.Status.Phase)
-				return reconcile.Result{}, nil
-			}
+		// Refresh cached object to avoid conflicts
+		if err := r.Client.Get(context.TODO(), req.NamespacedName, &podConfig); err != nil {
+			fmt.Printf("%v", err)
+			return reconcile.Result{}, err
+		}
 
-			configList, err := applyConfig(pod, &podConfig)
-			if err != nil {
-				fmt.Printf("%v", err)
-				return reconcile.Result{}, nil
+		// Record the interfaces of the pod, replacing the ones of a previous
+		// run since repaired links may have been recreated
+		isPodNamePresent := false
+		changed := false
+
+		for i, p := range podConfig.Status.PodConfigurations {
+			if p.PodName == configStatus.PodName {
+				isPodNamePresent = true
+				if !equality.Semantic.DeepEqual(p, configStatus) {
+					podConfig.Status.PodConfigurations[i] = configStatus
+					changed = true
+				}
 			}
-			fmt.Printf("%v", configList)
-
-			// Update config status for the actual pod in the list
-			configStatus := podconfigv1alpha1.PodConfiguration{PodName: pod.ObjectMeta.Name, ConfigList: configList}
+		}
+		if isPodNamePresent == false {
+			podConfig.Status.PodConfigurations = append(podConfig.Status.PodConfigurations, configStatus)
+			changed = true
+		}
+		if changed {
 			fmt.Printf("%v", podConfig.Status.PodConfigurations)
 
-			// Refresh cached object to avoid conflicts
-			if err := r.Client.Get(context.TODO(), req.NamespacedName, &podConfig); err != nil {
+			if err := r.Client.Status().Update(context.TODO(), &podConfig); err != nil {
 				fmt.Printf("%v", err)
 				return reconcile.Result{}, err
 			}
+		}
+	}
 
-			// If the pod config didn't reconcile completely update status
-			if podConfig.Status.Phase != podconfigv1alpha1.PodConfigConfigured {
+	configuredPods.WithLabelValues(r.NodeName, req.Namespace, req.Name).Set(float64(configured))
+	configuredAttachments.WithLabelValues(r.NodeName, req.Namespace, req.Name).Set(float64(configured * len(podConfig.Spec.NetworkAttachments)))
 
-				isPodNamePresent := false
+	// The phase and the Drifted condition cover the pods of every node
+	if err := r.Client.Get(context.TODO(), req.NamespacedName, &podConfig); err != nil {
+		fmt.Printf("%v", err)
+		return reconcile.Result{}, err
+	}
+	allPods := &corev1.PodList{}
+	err = r.Client.List(context.TODO(), allPods, client.InNamespace(podConfig.ObjectMeta.Namespace),
+		client.MatchingLabels{"podconfig": podConfig.ObjectMeta.Name})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if aggregateStatus(&podConfig.Status, allPods.Items) {
+		if err := r.Client.Status().Update(context.TODO(), &podConfig); err != nil {
+			fmt.Printf("%v", err)
+			return reconcile.Result{}, err
+		}
+	}
 
-				for _, p := range podConfig.Status.PodConfigurations {
-					if p.PodName == configStatus.PodName {
-						isPodNamePresent = true
-					}
-				}
-				if isPodNamePresent == false {
+	// Pods whose sandbox or containers weren't running are retried shortly
+	if notReady {
+		return reconcile.Result{RequeueAfter: 5 * time.Second}, nil
+	}
 
-					podConfig.Status.PodConfigurations = append(podConfig.Status.PodConfigurations, configStatus)
+	// Netlink updates trigger a reconcile right away, the resync is a
+	// fallback for the changes they miss
+	return reconcile.Result{RequeueAfter: r.ResyncInterval}, nil
+}
 
-					fmt.Printf("%v", podConfig.Status.PodConfigurations)
+// Removes the gauges of a deleted podconfig
+func (r *PodConfigReconciler) deleteMetrics(req ctrl.Request) {
+	configuredPods.DeleteLabelValues(r.NodeName, req.Namespace, req.Name)
+	configuredAttachments.DeleteLabelValues(r.NodeName, req.Namespace, req.Name)
+}
 
-					if err := r.Client.Status().Update(context.TODO(), &podConfig); err != nil {
-						fmt.Printf("%v", err)
-						return reconcile.Result{}, err
-					}
-				}
+// Records the policy verdict in the Admitted condition
+func (r *PodConfigReconciler) checkPolicies(podConfig *podconfigv1alpha1.PodConfig) (bool, error) {
+
+	violations, err := podconfigv1alpha1.PolicyViolations(context.TODO(), r.clusterReader(), podConfig)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	if len(violations) > 0 {
+		changed = setCondition(&podConfig.Status, podconfigv1alpha1.PodConfigAdmitted, corev1.ConditionFalse,
+			reasonPolicyViolation, violations.ToAggregate().Error())
+		if changed {
+			podEvents(r.Recorder, podConfig, nil)(corev1.EventTypeWarning, reasonPolicyViolation,
+				"Not applied to any more pods: %v", violations.ToAggregate())
+		}
+	} else {
+		changed = setCondition(&podConfig.Status, podconfigv1alpha1.PodConfigAdmitted, corev1.ConditionTrue,
+			"PolicyAllowed", "")
+	}
+
+	if changed {
+		if err := r.Client.Status().Update(context.TODO(), podConfig); err != nil {
+			return false, err
+		}
+	}
+	return len(violations) == 0, nil
+}
+
+// Records whether the namespace is within its quotas in the WithinQuota condition
+func (r *PodConfigReconciler) checkQuota(podConfig *podconfigv1alpha1.PodConfig) (bool, error) {
+
+	usage, err := podconfigv1alpha1.NamespaceUsage(context.TODO(), r.Client, podConfig.ObjectMeta.Namespace, nil)
+	if err != nil {
+		return false, err
+	}
+	violations, err := podconfigv1alpha1.QuotaViolations(context.TODO(), r.Client, podConfig.ObjectMeta.Namespace, usage)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	if len(violations) > 0 {
+		changed = setCondition(&podConfig.Status, podconfigv1alpha1.PodConfigWithinQuota, corev1.ConditionFalse,
+			reasonQuotaExceeded, violations.ToAggregate().Error())
+	} else {
+		changed = setCondition(&podConfig.Status, podconfigv1alpha1.PodConfigWithinQuota, corev1.ConditionTrue,
+			"WithinQuota", "")
+	}
+
+	if changed {
+		if err := r.Client.Status().Update(context.TODO(), podConfig); err != nil {
+			return false, err
+		}
+	}
+	return len(violations) == 0, nil
+}
+
+func isPodConfigured(podConfig podconfigv1alpha1.PodConfig, podName string) bool {
+	for _, p := range podConfig.Status.PodConfigurations {
+		if p.PodName == podName {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the host state and status of pods recorded on this node that
+// were deleted or finished. The cleanup works from the recorded state since
+// the pod process, and with it the pod network namespace, may be gone.
+func (r *PodConfigReconciler) cleanupGonePods(podConfig *podconfigv1alpha1.PodConfig) error {
+
+	configurations := []podconfigv1alpha1.PodConfiguration{}
+	for _, configuration := range podConfig.Status.PodConfigurations {
+
+		if r.NodeName != "" && configuration.Node != "" && configuration.Node != r.NodeName {
+			configurations = append(configurations, configuration)
+			continue
+		}
+
+		pod := &corev1.Pod{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: recordedNamespace(*podConfig, configuration), Name: configuration.PodName}, pod)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil && pod.ObjectMeta.DeletionTimestamp.IsZero() &&
+			pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			configurations = append(configurations, configuration)
+			continue
+		}
+		if errors.IsNotFound(err) {
+			pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: recordedNamespace(*podConfig, configuration), Name: configuration.PodName}}
+		}
+
+		owner := attachmentOwner{Namespace: podConfig.ObjectMeta.Namespace, PodConfig: podConfig.ObjectMeta.Name, Pod: configuration.PodName}
+		if err := cleanupHostState(owner, recordedBridges(*podConfig, configuration.PodName), podEvents(r.Recorder, podConfig, pod)); err != nil {
+			return err
+		}
+	}
+
+	if len(configurations) == len(podConfig.Status.PodConfigurations) {
+		return nil
+	}
+	podConfig.Status.PodConfigurations = configurations
+	return r.Client.Status().Update(context.TODO(), podConfig)
+}
+
+// Unmounts the mounts and reverts the file capabilities recorded for the
+// pods of this node, and clears them from the status once done. Those of
+// containers that are gone went away with them.
+func (r *PodConfigReconciler) revertPods(podConfig *podconfigv1alpha1.PodConfig) error {
+
+	changed := false
+	for i, configuration := range podConfig.Status.PodConfigurations {
+
+		if (len(configuration.Mounts) == 0 && len(configuration.FileCapabilities) == 0) ||
+			(r.NodeName != "" && configuration.Node != "" && configuration.Node != r.NodeName) {
+			continue
+		}
+
+		pod := &corev1.Pod{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: recordedNamespace(*podConfig, configuration), Name: configuration.PodName}, pod)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			event := podEvents(r.Recorder, podConfig, pod)
+			if err := unmountAll(*pod, configuration.Mounts, event); err != nil {
+				return err
+			}
+			if err := revertCapabilities(*pod, configuration.FileCapabilities, event); err != nil {
+				return err
 			}
 		}
 
-		// All pods for that pod configuration (a.k.a. podConfig) have been configured
-		// update general phase to configured
-		if err := r.Client.Get(context.TODO(), req.NamespacedName, &podConfig); err != nil {
-			fmt.Printf("%v", err)
-			return reconcile.Result{}, err
+		podConfig.Status.PodConfigurations[i].Mounts = nil
+		podConfig.Status.PodConfigurations[i].FileCapabilities = nil
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return r.Client.Status().Update(context.TODO(), podConfig)
+}
+
+// Pods of other nodes whose mounts or file capabilities are still to be
+// reverted by their own node. Pods being deleted or no longer running are
+// not waited for, their containers are gone or going.
+func (r *PodConfigReconciler) pendingReverts(podConfig *podconfigv1alpha1.PodConfig) ([]string, error) {
+
+	pending := []string{}
+	for _, configuration := range podConfig.Status.PodConfigurations {
+
+		if len(configuration.Mounts) == 0 && len(configuration.FileCapabilities) == 0 {
+			continue
 		}
-		podConfig.Status.Phase = podconfigv1alpha1.PodConfigConfigured
-		if err := r.Client.Status().Update(context.TODO(), &podConfig); err != nil {
-			fmt.Printf("%v", err)
-			return reconcile.Result{}, err
+
+		pod := &corev1.Pod{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: recordedNamespace(*podConfig, configuration), Name: configuration.PodName}, pod)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return pending, err
+		}
+		if pod.ObjectMeta.DeletionTimestamp.IsZero() && pod.Status.Phase == corev1.PodRunning {
+			pending = append(pending, configuration.PodName+" on "+configuration.Node)
 		}
+	}
+	return pending, nil
+}
 
+// Namespace of a recorded pod. Pods recorded before their namespace was
+// are in the namespace of the podconfig.
+func recordedNamespace(podConfig podconfigv1alpha1.PodConfig, configuration podconfigv1alpha1.PodConfiguration) string {
+	if configuration.Namespace != "" {
+		return configuration.Namespace
 	}
-	return reconcile.Result{}, nil
+	return podConfig.ObjectMeta.Namespace
+}
+
+// Bridges recorded for a pod, or for every pod when podName is empty,
+// along with the bridges of the current attachments
+func recordedBridges(podConfig podconfigv1alpha1.PodConfig, podName string) []string {
+
+	bridges := []string{}
+	for _, configuration := range podConfig.Status.PodConfigurations {
+		if podName != "" && configuration.PodName != podName {
+			continue
+		}
+		for _, iface := range configuration.Interfaces {
+			if iface.Bridge != "" && !containsString(bridges, iface.Bridge) {
+				bridges = append(bridges, iface.Bridge)
+			}
+		}
+	}
+	for _, na := range podConfig.Spec.NetworkAttachments {
+		if na.Master != "" && !containsString(bridges, na.Master) {
+			bridges = append(bridges, na.Master)
+		}
+	}
+	return bridges
+}
+
+// Pods are reconciled through the podconfig named in their label, their
+// deletion is what triggers the cleanup of their attachments
+func (r *PodConfigReconciler) podConfigForPod(obj handler.MapObject) []reconcile.Request {
+
+	name, ok := obj.Meta.GetLabels()["podconfig"]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: obj.Meta.GetNamespace(),
+		Name:      name,
+	}}}
+}
+
+// Policy changes are reconciled through the podconfigs they may affect
+func (r *PodConfigReconciler) podConfigsForPolicy(obj handler.MapObject) []reconcile.Request {
+
+	requests := []reconcile.Request{}
+
+	podConfigList := &podconfigv1alpha1.PodConfigList{}
+	if err := r.Client.List(context.TODO(), podConfigList); err != nil {
+		fmt.Printf("Error listing podconfigs for policy %v: %v\n", obj.Meta.GetName(), err)
+		return requests
+	}
+	for _, podConfig := range podConfigList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: podConfig.ObjectMeta.Namespace,
+			Name:      podConfig.ObjectMeta.Name,
+		}})
+	}
+	return requests
+}
+
+// The multi namespace cache can't get cluster scoped objects and lists
+// them once per namespace, those are read through the cluster reader
+func (r *PodConfigReconciler) clusterReader() client.Reader {
+	if r.ClusterReader != nil {
+		return r.ClusterReader
+	}
+	return r.Client
 }
 
 func (r *PodConfigReconciler) listPodsWithMatchingLabels(podConfig podconfigv1alpha1.PodConfig) (*corev1.PodList, error) {
 	// Get the list of pods that have a podconfig label
 	podList := &corev1.PodList{}
-	err := r.Client.List(context.TODO(), podList, client.MatchingLabels{"podconfig": podConfig.ObjectMeta.Name})
+	err := r.Client.List(context.TODO(), podList, client.InNamespace(podConfig.ObjectMeta.Namespace),
+		client.MatchingLabels{"podconfig": podConfig.ObjectMeta.Name})
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -206,15 +605,35 @@ func (r *PodConfigReconciler) listPodsWithMatchingLabels(podConfig podconfigv1al
 	if len(podList.Items) <= 0 {
 		return &corev1.PodList{}, fmt.Errorf("empty pod list")
 	}
+
+	// Host configuration can only be done for pods running on the same node
+	if r.NodeName != "" {
+		nodePodList := &corev1.PodList{}
+		for _, pod := range podList.Items {
+			if pod.Spec.NodeName == r.NodeName {
+				nodePodList.Items = append(nodePodList.Items, pod)
+			}
+		}
+		return nodePodList, nil
+	}
 	return podList, nil
 }
 
 // SetupWithManager for the podconfig controller
 func (r *PodConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&podconfigv1alpha1.PodConfig{}).
 		Owns(&appsv1.Deployment{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &podconfigv1alpha1.PodConfigPolicy{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.podConfigsForPolicy),
+		}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.podConfigForPod),
+		})
+	if r.LinkEvents != nil {
+		builder = builder.Watches(&source.Channel{Source: r.LinkEvents}, &handler.EnqueueRequestForObject{})
+	}
+	return builder.Complete(r)
 }
 
 // Helper functions to check and remove string from a slice of strings.
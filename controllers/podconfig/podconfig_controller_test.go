@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newLabeledPod(name, namespace, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"podconfig": "pc"}},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+}
+
+var _ = Describe("PodConfig reconciler", func() {
+
+	var r *PodConfigReconciler
+
+	podConfig := podconfigv1alpha1.PodConfig{ObjectMeta: metav1.ObjectMeta{Name: "pc", Namespace: "tenant"}}
+
+	podNames := func(podList *corev1.PodList) []string {
+		names := []string{}
+		for _, pod := range podList.Items {
+			names = append(names, pod.Namespace+"/"+pod.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		r = &PodConfigReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+			newLabeledPod("cnf-a", "tenant", "node1"),
+			newLabeledPod("cnf-b", "tenant", "node2"),
+			newLabeledPod("cnf-a", "other", "node1"),
+		)}
+	})
+
+	It("lists the labeled pods of the podconfig namespace only", func() {
+		podList, err := r.listPodsWithMatchingLabels(podConfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podNames(podList)).To(ConsistOf("tenant/cnf-a", "tenant/cnf-b"))
+	})
+
+	It("keeps the pods of its node when running per node", func() {
+		r.NodeName = "node1"
+
+		podList, err := r.listPodsWithMatchingLabels(podConfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podNames(podList)).To(ConsistOf("tenant/cnf-a"))
+	})
+
+	It("reads cluster scoped objects through its client by default", func() {
+		Expect(r.clusterReader()).To(BeIdenticalTo(r.Client))
+
+		reader := fake.NewFakeClientWithScheme(scheme.Scheme)
+		r.ClusterReader = reader
+		Expect(r.clusterReader()).To(BeIdenticalTo(reader))
+	})
+
+	Describe("recorded pods", func() {
+
+		recorded := podconfigv1alpha1.PodConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "pc", Namespace: "tenant"},
+			Spec: podconfigv1alpha1.PodConfigSpec{NetworkAttachments: []podconfigv1alpha1.Link{
+				{Name: "net1", Master: "br1"},
+				{Name: "net2", Master: "br2"},
+			}},
+			Status: podconfigv1alpha1.PodConfigStatus{PodConfigurations: []podconfigv1alpha1.PodConfiguration{
+				{PodName: "cnf-a", Namespace: "tenant", Interfaces: []podconfigv1alpha1.InterfaceStatus{{Bridge: "br0"}, {Bridge: "br1"}}},
+				{PodName: "cnf-b", Interfaces: []podconfigv1alpha1.InterfaceStatus{{Bridge: "br3"}}},
+			}},
+		}
+
+		It("are in the namespace of the podconfig when recorded without one", func() {
+			Expect(recordedNamespace(recorded, recorded.Status.PodConfigurations[1])).To(Equal("tenant"))
+			Expect(recordedNamespace(recorded, podconfigv1alpha1.PodConfiguration{PodName: "cnf", Namespace: "other"})).To(Equal("other"))
+		})
+
+		It("have their bridges cleaned up along with the current ones", func() {
+			Expect(recordedBridges(recorded, "cnf-a")).To(Equal([]string{"br0", "br1", "br2"}))
+			Expect(recordedBridges(recorded, "")).To(Equal([]string{"br0", "br1", "br3", "br2"}))
+		})
+	})
+
+	Describe("reverts on deletion", func() {
+
+		var deleting *podconfigv1alpha1.PodConfig
+
+		runningPod := func(name, nodeName string) *corev1.Pod {
+			pod := newLabeledPod(name, "tenant", nodeName)
+			pod.Status.Phase = corev1.PodRunning
+			return pod
+		}
+
+		BeforeEach(func() {
+			deleting = &podconfigv1alpha1.PodConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "pc", Namespace: "tenant"},
+				Status: podconfigv1alpha1.PodConfigStatus{PodConfigurations: []podconfigv1alpha1.PodConfiguration{
+					{PodName: "cnf-a", Namespace: "tenant", Node: "node1", Mounts: []string{"cnf:/etc/license"}},
+					{PodName: "cnf-b", Namespace: "tenant", Node: "node2", Mounts: []string{"cnf:/etc/license"}},
+					{PodName: "cnf-c", Namespace: "tenant", Node: "node2", FileCapabilities: []podconfigv1alpha1.FileCapabilityStatus{
+						{Container: "cnf", Path: "/usr/bin/cnf-agent", Add: []string{"NET_RAW"}},
+					}},
+					{PodName: "cnf-d", Namespace: "tenant", Node: "node2"},
+				}},
+			}
+
+			s := runtime.NewScheme()
+			Expect(scheme.AddToScheme(s)).To(Succeed())
+			Expect(podconfigv1alpha1.AddToScheme(s)).To(Succeed())
+
+			stopped := runningPod("cnf-c", "node2")
+			stopped.Status.Phase = corev1.PodSucceeded
+			r = &PodConfigReconciler{NodeName: "node1", Client: fake.NewFakeClientWithScheme(s,
+				deleting, runningPod("cnf-b", "node2"), stopped, runningPod("cnf-d", "node2"),
+			)}
+		})
+
+		It("wait for the running pods of other nodes", func() {
+			pending, err := r.pendingReverts(deleting)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pending).To(Equal([]string{"cnf-b on node2"}))
+		})
+
+		It("clear the pods of the node once reverted", func() {
+			Expect(r.revertPods(deleting)).To(Succeed())
+
+			updated := &podconfigv1alpha1.PodConfig{}
+			Expect(r.Client.Get(context.TODO(), types.NamespacedName{Namespace: "tenant", Name: "pc"}, updated)).To(Succeed())
+			Expect(updated.Status.PodConfigurations[0].Mounts).To(BeEmpty())
+			Expect(updated.Status.PodConfigurations[1].Mounts).To(Equal([]string{"cnf:/etc/license"}))
+			Expect(updated.Status.PodConfigurations[2].FileCapabilities).To(HaveLen(1))
+		})
+	})
+})
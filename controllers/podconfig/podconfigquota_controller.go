@@ -0,0 +1,105 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+)
+
+// PodConfigQuotaReconciler keeps the usage of podconfig quotas up to date
+type PodConfigQuotaReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=podconfig.opdev.io,resources=podconfigquotas/status,verbs=get;update;patch
+
+// Reconcile function for the PodConfigQuota instance
+func (r *PodConfigQuotaReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+
+	quota := &podconfigv1alpha1.PodConfigQuota{}
+	err := r.Client.Get(context.TODO(), req.NamespacedName, quota)
+	if apierrors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	usage, err := podconfigv1alpha1.NamespaceUsage(context.TODO(), r.Client, quota.ObjectMeta.Namespace, nil)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Every operator instance computes the same usage, only changes are written
+	if quota.Status.Used == usage {
+		return reconcile.Result{}, nil
+	}
+	quota.Status.Used = usage
+	if err := r.Client.Status().Update(context.TODO(), quota); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// Podconfig and pod changes update the quotas of their namespace
+func (r *PodConfigQuotaReconciler) quotasInNamespace(obj handler.MapObject) []reconcile.Request {
+
+	requests := []reconcile.Request{}
+
+	quotaList := &podconfigv1alpha1.PodConfigQuotaList{}
+	err := r.Client.List(context.TODO(), quotaList, client.InNamespace(obj.Meta.GetNamespace()))
+	if err != nil {
+		fmt.Printf("Error listing podconfig quotas in %v: %v\n", obj.Meta.GetNamespace(), err)
+		return requests
+	}
+	for _, quota := range quotaList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: quota.ObjectMeta.Namespace,
+			Name:      quota.ObjectMeta.Name,
+		}})
+	}
+	return requests
+}
+
+// SetupWithManager for the podconfig quota controller
+func (r *PodConfigQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	toQuotas := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(r.quotasInNamespace),
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&podconfigv1alpha1.PodConfigQuota{}).
+		Watches(&source.Kind{Type: &podconfigv1alpha1.PodConfig{}}, toQuotas).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, toQuotas).
+		Complete(r)
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"github.com/vishvananda/netlink"
+)
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Sweeper periodically removes the host veths, bridges and ip allocations
+// the operator left behind for pods or podconfigs that no longer exist.
+// It runs on every node, looking only at the host namespace of its own node.
+type Sweeper struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	NodeName string
+	Interval time.Duration
+
+	// Namespaces watched by the operator, empty means all. Attachments of
+	// other namespaces can't be looked up in the cache and are left alone.
+	Namespaces []string
+
+	// bridges found without ports on the previous sweep. A bridge is only
+	// removed when it stays empty for two sweeps so that a bridge just
+	// created by the reconciler is not taken before its first port.
+	emptyBridges map[string]bool
+}
+
+// Start runs the sweeper until the stop channel is closed
+func (s *Sweeper) Start(stop <-chan struct{}) error {
+	s.emptyBridges = map[string]bool{}
+	wait.Until(s.sweep, s.Interval, stop)
+	return nil
+}
+
+// NeedLeaderElection is false since every node has its own host state
+func (s *Sweeper) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Sweeper) sweep() {
+
+	targetNS, err := ns.GetNS(hostNetNSPath())
+	if err != nil {
+		s.Log.Error(err, "error getting host network namespace")
+		return
+	}
+
+	var links []netlink.Link
+	err = targetNS.Do(func(hostNs ns.NetNS) error {
+		links, err = netlink.LinkList()
+		return err
+	})
+	if err != nil {
+		s.Log.Error(err, "failed to list host links")
+		return
+	}
+
+	// Host veths whose pod, podconfig or attachment are gone
+	for _, link := range links {
+		owner, attachment, ok := parsePortAlias(link.Attrs().Alias)
+		if !ok {
+			continue
+		}
+		orphan, err := s.isOrphan(owner, attachment)
+		if err != nil {
+			s.Log.Error(err, "failed to check attachment owner", "link", link.Attrs().Name)
+			continue
+		}
+		if !orphan {
+			continue
+		}
+		if err := s.deleteHostLink(targetNS, link.Attrs().Name); err != nil {
+			s.Log.Error(err, "failed to delete orphaned veth", "link", link.Attrs().Name)
+			continue
+		}
+		interfaces.remove(link.Attrs().Alias)
+		s.reclaimed("veth", "ReclaimedVeth", fmt.Sprintf("Deleted orphaned veth %s of %s/%s attachment %s", link.Attrs().Name, owner.Namespace, owner.Pod, attachment))
+	}
+
+	// IPs allocated to attachments whose pods are gone. Their veths
+	// normally go away with the pod network namespace.
+	for _, alias := range ips.Owners() {
+		owner, attachment, ok := parsePortAlias(alias)
+		if !ok {
+			continue
+		}
+		orphan, err := s.isOrphan(owner, attachment)
+		if err != nil || !orphan {
+			continue
+		}
+		interfaces.remove(alias)
+		for _, ip := range ips.ReleaseOwner(alias) {
+			s.reclaimed("ip", "ReleasedIP", fmt.Sprintf("Released ip %s of %s/%s attachment %s", ip, owner.Namespace, owner.Pod, attachment))
+		}
+	}
+
+	// Operator created bridges left without ports
+	emptyBridges := map[string]bool{}
+	bridges := 0
+	for _, link := range links {
+		if link.Type() != "bridge" || !isOwnedBridge(link.Attrs().Alias) {
+			continue
+		}
+		bridges++
+		ports := 0
+		for _, port := range links {
+			if port.Attrs().MasterIndex == link.Attrs().Index {
+				ports++
+			}
+		}
+		if ports > 0 {
+			continue
+		}
+		if !s.emptyBridges[link.Attrs().Name] {
+			emptyBridges[link.Attrs().Name] = true
+			continue
+		}
+		// deleteBridge checks ownership and ports again before deleting
+		deleted, err := deleteBridge(link.Attrs().Name)
+		if err != nil {
+			s.Log.Error(err, "failed to delete orphaned bridge", "bridge", link.Attrs().Name)
+			continue
+		}
+		if !deleted {
+			continue
+		}
+		s.reclaimed("bridge", "ReclaimedBridge", fmt.Sprintf("Deleted orphaned bridge %s", link.Attrs().Name))
+		bridges--
+	}
+	s.emptyBridges = emptyBridges
+
+	nodeBridges.WithLabelValues(s.NodeName).Set(float64(bridges))
+}
+
+// An attachment is orphaned when its pod or podconfig no longer exists,
+// the pod has terminated or the attachment was removed from the podconfig
+func (s *Sweeper) isOrphan(owner attachmentOwner, attachment string) (bool, error) {
+
+	if len(s.Namespaces) > 0 && !containsString(s.Namespaces, owner.Namespace) {
+		return false, nil
+	}
+
+	pod := &corev1.Pod{}
+	err := s.Client.Get(context.TODO(), types.NamespacedName{Namespace: owner.Namespace, Name: owner.Pod}, pod)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return true, nil
+	}
+
+	podConfig := &podconfigv1alpha1.PodConfig{}
+	err = s.Client.Get(context.TODO(), types.NamespacedName{Namespace: owner.Namespace, Name: owner.PodConfig}, podConfig)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, na := range podConfig.Spec.NetworkAttachments {
+		if na.Name == attachment {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *Sweeper) deleteHostLink(targetNS ns.NetNS, name string) error {
+
+	return targetNS.Do(func(hostNs ns.NetNS) error {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", name, err)
+		}
+		return netlink.LinkDel(link)
+	})
+}
+
+// Records a reclaimed resource as a metric and as an event on the node
+func (s *Sweeper) reclaimed(kind string, reason string, message string) {
+
+	sweeperReclaimed.WithLabelValues(s.NodeName, kind).Inc()
+	s.Log.Info(message)
+
+	if s.NodeName == "" {
+		return
+	}
+	node := &corev1.ObjectReference{Kind: "Node", Name: s.NodeName}
+	s.Recorder.Event(node, corev1.EventTypeNormal, reason, message)
+}
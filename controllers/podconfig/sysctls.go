@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sets the sysctls in the network namespace of the pod. /proc/sys/net
+// belongs to the network namespace of the thread opening it. Values are
+// only written when they differ, and are lost with the pod.
+func applySysctls(netNS podNetNS, sysctls []podconfigv1alpha1.SysctlSpec, event eventFunc) error {
+
+	if len(sysctls) == 0 {
+		return nil
+	}
+
+	return doInNetNS(netNS.path, func() error {
+		for _, s := range sysctls {
+
+			// Checked again for podconfigs admitted without the webhook
+			if !podconfigv1alpha1.SysctlNamespaced(s.Name) {
+				return fmt.Errorf("sysctl %v is not a net sysctl", s.Name)
+			}
+
+			current, err := sysctl.Sysctl(s.Name)
+			if err != nil {
+				return fmt.Errorf("failed to read sysctl %v: %v", s.Name, err)
+			}
+			if current == s.Value {
+				continue
+			}
+			if _, err := sysctl.Sysctl(s.Name, s.Value); err != nil {
+				return fmt.Errorf("failed to set sysctl %v to %q: %v", s.Name, s.Value, err)
+			}
+			event(corev1.EventTypeNormal, reasonSysctlSet, "Set sysctl %s to %q", s.Name, s.Value)
+		}
+		return nil
+	})
+}
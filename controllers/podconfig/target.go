@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Resolves a target to the host pid of a process whose namespaces the
+// configuration is applied in: the container main process, or the first
+// process of the container whose command line matches.
+func resolveTarget(pod corev1.Pod, target podconfigv1alpha1.TargetSpec, conn *grpc.ClientConn) (string, error) {
+
+	containerID := ""
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.Name != target.Container || containerStatus.State.Running == nil {
+			continue
+		}
+		parts := strings.SplitN(containerStatus.ContainerID, "://", 2)
+		if len(parts) == 2 {
+			containerID = parts[1]
+		}
+	}
+	if containerID == "" {
+		return "", fmt.Errorf("container %v is not running", target.Container)
+	}
+
+	containerStatusResponse, err := getCRIOContainerStatus(containerID, conn)
+	if err != nil {
+		return "", fmt.Errorf("Error getting CRIO container status: %v", err)
+	}
+	pid, ok := parseCRIOContainerInfo(containerStatusResponse)["pid"].(float64)
+	if !ok || pid <= 0 {
+		return "", fmt.Errorf("no pid in the status of container %v", target.Container)
+	}
+	mainPid := strconv.Itoa(int(pid))
+
+	if target.Command == "" {
+		return mainPid, nil
+	}
+	return findContainerProcess(mainPid, target.Command)
+}
+
+// Looks for a process sharing the pid and mount namespaces of the container
+// main process whose command line contains command
+func findContainerProcess(mainPid string, command string) (string, error) {
+
+	pidNS, err := os.Readlink(procNSPath(mainPid, "pid"))
+	if err != nil {
+		return "", err
+	}
+	mntNS, err := os.Readlink(procNSPath(mainPid, "mnt"))
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := ioutil.ReadDir(HostProc)
+	if err != nil {
+		return "", err
+	}
+	pids := []int{}
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		p := strconv.Itoa(pid)
+
+		// Processes may exit while they are looked at
+		if ns, err := os.Readlink(procNSPath(p, "pid")); err != nil || ns != pidNS {
+			continue
+		}
+		if ns, err := os.Readlink(procNSPath(p, "mnt")); err != nil || ns != mntNS {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(HostProc + "/" + p + "/cmdline")
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})), command) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no process matching %q in the container", command)
+}
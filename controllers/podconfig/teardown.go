@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Finalizer set on the pods of podconfigs with spec.teardown.podFinalizer
+const podFinalizer = "podconfig.opdev.io/teardown"
+
+// Whether the pods of the podconfig get the teardown finalizer
+func wantsPodFinalizer(podConfig podconfigv1alpha1.PodConfig) bool {
+	return podConfig.Spec.Teardown != nil && podConfig.Spec.Teardown.PodFinalizer
+}
+
+func teardownTimeout(podConfig podconfigv1alpha1.PodConfig) time.Duration {
+	if podConfig.Spec.Teardown != nil && podConfig.Spec.Teardown.TimeoutSeconds != nil {
+		return time.Duration(*podConfig.Spec.Teardown.TimeoutSeconds) * time.Second
+	}
+	return podconfigv1alpha1.DefaultTeardownTimeoutSeconds * time.Second
+}
+
+// Adds the teardown finalizer to a pod before it is configured
+func (r *PodConfigReconciler) addPodFinalizer(pod *corev1.Pod) error {
+
+	if containsString(pod.GetFinalizers(), podFinalizer) {
+		return nil
+	}
+	pod.SetFinalizers(append(pod.GetFinalizers(), podFinalizer))
+	return r.Client.Update(context.TODO(), pod)
+}
+
+func (r *PodConfigReconciler) removePodFinalizer(pod *corev1.Pod) error {
+
+	if !containsString(pod.GetFinalizers(), podFinalizer) {
+		return nil
+	}
+	pod.SetFinalizers(removeString(pod.GetFinalizers(), podFinalizer))
+	return r.Client.Update(context.TODO(), pod)
+}
+
+// Releases the deleted pods of the node that carry the teardown finalizer
+// once the host side of their attachments is cleaned up. The finalizer of
+// a pod whose teardown keeps failing is removed when the timeout expires,
+// by the operator of any node in case the one of the pod is down.
+// Returns how long to wait before checking the failed ones again.
+func (r *PodConfigReconciler) teardownPods(podConfig *podconfigv1alpha1.PodConfig) (time.Duration, error) {
+
+	podList := &corev1.PodList{}
+	err := r.Client.List(context.TODO(), podList, client.InNamespace(podConfig.ObjectMeta.Namespace),
+		client.MatchingLabels{"podconfig": podConfig.ObjectMeta.Name})
+	if err != nil {
+		return 0, err
+	}
+
+	var retry time.Duration
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+
+		if pod.ObjectMeta.DeletionTimestamp.IsZero() || !containsString(pod.GetFinalizers(), podFinalizer) {
+			continue
+		}
+		event := podEvents(r.Recorder, podConfig, pod)
+		remaining := time.Until(pod.ObjectMeta.DeletionTimestamp.Add(teardownTimeout(*podConfig)))
+
+		if r.NodeName != "" && pod.Spec.NodeName != r.NodeName {
+			if remaining > 0 {
+				if retry == 0 || remaining < retry {
+					retry = remaining
+				}
+				continue
+			}
+			event(corev1.EventTypeWarning, reasonTeardownTimedOut, "Node %v didn't tear down the pod within %v, releasing it", pod.Spec.NodeName, teardownTimeout(*podConfig))
+			if err := r.removePodFinalizer(pod); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		owner := attachmentOwner{Namespace: pod.ObjectMeta.Namespace, PodConfig: podConfig.ObjectMeta.Name, Pod: pod.ObjectMeta.Name}
+		err := cleanupHostState(owner, recordedBridges(*podConfig, pod.ObjectMeta.Name), event)
+		if err != nil {
+			if remaining > 0 {
+				event(corev1.EventTypeWarning, reasonTeardownFailed, "Teardown failed, retrying: %v", err)
+				if retry == 0 || remaining < retry {
+					retry = remaining
+				}
+				continue
+			}
+			event(corev1.EventTypeWarning, reasonTeardownTimedOut, "Teardown didn't complete within %v, releasing pod: %v", teardownTimeout(*podConfig), err)
+		}
+
+		if err := r.removePodFinalizer(pod); err != nil {
+			return 0, err
+		}
+	}
+
+	// Failed teardowns are retried a few times before the timeout, pods
+	// of other nodes are checked as often in case their node released them
+	if retry > 5*time.Second {
+		retry = 5 * time.Second
+	}
+	return retry, nil
+}
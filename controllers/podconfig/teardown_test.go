@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Pod teardown", func() {
+
+	var (
+		r         *PodConfigReconciler
+		podConfig *podconfigv1alpha1.PodConfig
+	)
+
+	timeout := int32(30)
+
+	// A pod of another node deleted some time ago, held by the finalizer
+	newDeletedPod := func(name string, deletedAgo time.Duration) *corev1.Pod {
+		pod := newLabeledPod(name, "tenant", "node2")
+		deleted := metav1.NewTime(time.Now().Add(-deletedAgo))
+		pod.DeletionTimestamp = &deleted
+		pod.Finalizers = []string{podFinalizer}
+		return pod
+	}
+
+	finalizersOf := func(name string) []string {
+		pod := &corev1.Pod{}
+		Expect(r.Client.Get(context.TODO(), client.ObjectKey{Namespace: "tenant", Name: name}, pod)).To(Succeed())
+		return pod.Finalizers
+	}
+
+	BeforeEach(func() {
+		podConfig = &podconfigv1alpha1.PodConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "pc", Namespace: "tenant"},
+			Spec: podconfigv1alpha1.PodConfigSpec{
+				Teardown: &podconfigv1alpha1.TeardownSpec{PodFinalizer: true, TimeoutSeconds: &timeout},
+			},
+		}
+	})
+
+	It("is opted in with a timeout defaulting to a minute", func() {
+		Expect(wantsPodFinalizer(*podConfig)).To(BeTrue())
+		Expect(teardownTimeout(*podConfig)).To(Equal(30 * time.Second))
+
+		podConfig.Spec.Teardown = nil
+		Expect(wantsPodFinalizer(*podConfig)).To(BeFalse())
+		Expect(teardownTimeout(*podConfig)).To(Equal(time.Minute))
+	})
+
+	It("adds and removes the finalizer once", func() {
+		pod := newLabeledPod("cnf", "tenant", "node1")
+		r = &PodConfigReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, pod)}
+
+		Expect(r.addPodFinalizer(pod)).To(Succeed())
+		Expect(r.addPodFinalizer(pod)).To(Succeed())
+		Expect(finalizersOf("cnf")).To(Equal([]string{podFinalizer}))
+
+		Expect(r.removePodFinalizer(pod)).To(Succeed())
+		Expect(r.removePodFinalizer(pod)).To(Succeed())
+		Expect(finalizersOf("cnf")).To(BeEmpty())
+	})
+
+	Describe("of pods on other nodes", func() {
+
+		BeforeEach(func() {
+			r = &PodConfigReconciler{
+				NodeName: "node1",
+				Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+					newDeletedPod("cnf-a", 10*time.Second),
+					newDeletedPod("cnf-b", time.Minute),
+				),
+			}
+		})
+
+		It("releases them once their node let the timeout expire", func() {
+			retry, err := r.teardownPods(podConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(finalizersOf("cnf-a")).To(ConsistOf(podFinalizer))
+			Expect(finalizersOf("cnf-b")).To(BeEmpty())
+			Expect(retry).To(Equal(5 * time.Second))
+		})
+
+		It("checks them again before the timeout expires", func() {
+			timeout = 12
+			defer func() { timeout = 30 }()
+
+			retry, err := r.teardownPods(podConfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retry).To(BeNumerically("<=", 2*time.Second))
+			Expect(retry).To(BeNumerically(">", 0))
+		})
+	})
+})
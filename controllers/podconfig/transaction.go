@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// step is a configuration change applied to a pod or to the host
+type step struct {
+	name string
+	// undo reverts the change, nil when the change is reverted by
+	// undoing an earlier step (e.g. addresses go away with their link)
+	undo func() error
+}
+
+// transaction records every step applied while configuring a pod so that
+// the completed steps can be undone in reverse order when a later one fails
+type transaction struct {
+	steps []step
+}
+
+// Runs apply and records it as a completed step when it succeeds
+func (t *transaction) do(name string, apply func() error, undo func() error) error {
+
+	defer observeStep(stepOperation(name), time.Now())
+
+	if err := apply(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	t.steps = append(t.steps, step{name: name, undo: undo})
+	return nil
+}
+
+// Names of the completed steps in the order they were applied
+func (t *transaction) completed() []string {
+
+	names := []string{}
+	for _, s := range t.steps {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// Undoes the completed steps in reverse order. All steps are attempted
+// even if some of them fail.
+func (t *transaction) rollback() error {
+
+	errs := []string{}
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		s := t.steps[i]
+		if s.undo == nil {
+			continue
+		}
+		if err := s.undo(); err != nil {
+			errs = append(errs, fmt.Sprintf("undo %s: %v", s.name, err))
+		}
+	}
+	t.steps = nil
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Runs fn inside the network namespace at path. Used by undo functions
+// that run after the namespace of the original step has been left.
+func doInNetNS(path string, fn func() error) error {
+
+	targetNS, err := getNetNS(path)
+	if err != nil {
+		return fmt.Errorf("error getting network namespace %v: %v", path, err)
+	}
+	defer targetNS.Close()
+
+	return targetNS.Do(func(ns.NetNS) error {
+		return fn()
+	})
+}
+
+// Opens the network namespace at path, timed as the netns_entry step
+func getNetNS(path string) (ns.NetNS, error) {
+
+	defer observeStep("netns_entry", time.Now())
+
+	return ns.GetNS(path)
+}
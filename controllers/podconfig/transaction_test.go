@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Configuration transaction", func() {
+
+	var (
+		tx     *transaction
+		undone []string
+	)
+
+	BeforeEach(func() {
+		tx = &transaction{}
+		undone = []string{}
+	})
+
+	undo := func(name string) func() error {
+		return func() error {
+			undone = append(undone, name)
+			return nil
+		}
+	}
+
+	noop := func() error { return nil }
+
+	It("records the steps that were applied", func() {
+		Expect(tx.do("create veth", noop, undo("create veth"))).To(Succeed())
+		Expect(tx.do("set veth up", noop, nil)).To(Succeed())
+
+		Expect(tx.completed()).To(Equal([]string{"create veth", "set veth up"}))
+	})
+
+	It("does not record a failed step and names it in the error", func() {
+		Expect(tx.do("create veth", noop, undo("create veth"))).To(Succeed())
+
+		err := tx.do("add ip", func() error { return errors.New("no free ip left") }, undo("add ip"))
+		Expect(err).To(MatchError("add ip: no free ip left"))
+		Expect(tx.completed()).To(Equal([]string{"create veth"}))
+	})
+
+	It("undoes the completed steps in reverse order", func() {
+		Expect(tx.do("allocate gateway", noop, undo("allocate gateway"))).To(Succeed())
+		Expect(tx.do("create bridge", noop, undo("create bridge"))).To(Succeed())
+		Expect(tx.do("set bridge options", noop, nil)).To(Succeed())
+		Expect(tx.do("create veth", noop, undo("create veth"))).To(Succeed())
+
+		Expect(tx.rollback()).To(Succeed())
+		Expect(undone).To(Equal([]string{"create veth", "create bridge", "allocate gateway"}))
+		Expect(tx.completed()).To(BeEmpty())
+	})
+
+	It("attempts every undo and reports the ones that failed", func() {
+		Expect(tx.do("create bridge", noop, undo("create bridge"))).To(Succeed())
+		Expect(tx.do("create veth", noop, func() error { return errors.New("busy") })).To(Succeed())
+		Expect(tx.do("add routes", noop, func() error { return errors.New("gone") })).To(Succeed())
+
+		err := tx.rollback()
+		Expect(err).To(MatchError("undo add routes: gone; undo create veth: busy"))
+		Expect(undone).To(Equal([]string{"create bridge"}))
+	})
+})
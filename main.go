@@ -18,13 +18,20 @@ package main
 
 import (
 	"flag"
+	"net"
 	"os"
+	"strings"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	podconfigv1alpha1 "github.com/opdev/podconfig-operator/apis/podconfig/v1alpha1"
@@ -47,35 +54,193 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var nodeName string
+	var sweepInterval time.Duration
+	var resyncInterval time.Duration
+	var allowedBridges string
+	var allowedHostPaths string
+	var allowedCapabilities string
+	var cidrPool string
+	var subnetPrefix int
+	var watchNamespaces string
+	var hostProc string
+	var maxCaptures int
+	var captureBudget string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"),
+		"Name of the node the operator runs on. Only pods on this node are configured when set.")
+	flag.DurationVar(&sweepInterval, "sweep-interval", time.Minute,
+		"Interval between sweeps for orphaned host veths, bridges and ip allocations.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 5*time.Minute,
+		"Interval between checks of configured pods for links that drifted from the podconfig status. Disabled when zero.")
+	flag.StringVar(&allowedBridges, "allowed-bridges", "",
+		"Comma separated list of bridges podconfigs may use. Any bridge is allowed when empty.")
+	flag.StringVar(&allowedHostPaths, "allowed-host-paths", "",
+		"Comma separated list of host paths podconfigs may bind mount into containers, along with the paths below them. No mounts are allowed when empty.")
+	flag.StringVar(&allowedCapabilities, "allowed-capabilities", "",
+		"Comma separated list of capabilities, such as NET_RAW, podconfigs may set on container binaries. No capabilities are allowed when empty.")
+	flag.StringVar(&cidrPool, "cidr-pool", "",
+		"IPv4 network the defaulting webhook picks attachment CIDRs from when none is given.")
+	flag.IntVar(&subnetPrefix, "cidr-pool-prefix", podconfigv1alpha1.DefaultSubnetPrefix,
+		"Prefix length of the CIDRs picked from the cidr pool.")
+	flag.StringVar(&watchNamespaces, "namespaces", os.Getenv("WATCH_NAMESPACE"),
+		"Comma separated list of namespaces to watch. All namespaces are watched when empty.")
+	flag.StringVar(&hostProc, "host-proc", podconfigcontroller.HostProc,
+		"Path the proc file system of the host is mounted at. Network namespaces of the host and of pods without a runtime netns path are entered through it.")
+	flag.IntVar(&maxCaptures, "max-captures", 2,
+		"Packet captures running at once on the node, others wait for them to end.")
+	flag.StringVar(&captureBudget, "capture-budget", "2Gi",
+		"Size the pcap files of the packet captures running on the node may grow to in total.")
 	flag.Parse()
 
+	podconfigcontroller.HostProc = hostProc
+	podconfigcontroller.AllowedHostPaths = splitList(allowedHostPaths)
+	podconfigcontroller.AllowedCapabilities = splitList(allowedCapabilities)
+
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	byteBudget, err := resource.ParseQuantity(captureBudget)
+	if err != nil {
+		setupLog.Error(err, "invalid capture budget", "capture-budget", captureBudget)
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{
 		Scheme:             scheme,
-		Namespace:          "cnf-test",
 		MetricsBindAddress: metricsAddr,
 		Port:               9443,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "4dfcde9d.opdev.io",
-	})
+	}
+
+	// A single namespace restricts the manager cache, several need one
+	// cache per namespace and no namespace watches the whole cluster
+	namespaces := splitList(watchNamespaces)
+	switch len(namespaces) {
+	case 0:
+		setupLog.Info("watching all namespaces")
+	case 1:
+		options.Namespace = namespaces[0]
+		setupLog.Info("watching a single namespace", "namespace", namespaces[0])
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// The multi namespace cache can't serve cluster scoped objects
+	var clusterReader client.Reader = mgr.GetClient()
+	if len(namespaces) > 1 {
+		clusterReader = mgr.GetAPIReader()
+	}
+
+	// Podconfigs are reconciled as soon as their links change on the node
+	linkEvents := make(chan event.GenericEvent, 100)
+	if err = mgr.Add(&podconfigcontroller.LinkWatcher{
+		Log:      ctrl.Log.WithName("linkwatcher"),
+		Events:   linkEvents,
+		Interval: sweepInterval,
+	}); err != nil {
+		setupLog.Error(err, "unable to add link watcher")
+		os.Exit(1)
+	}
+
 	if err = (&podconfigcontroller.PodConfigReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("PodConfig"),
+		Scheme:         mgr.GetScheme(),
+		NodeName:       nodeName,
+		ClusterReader:  clusterReader,
+		Recorder:       mgr.GetEventRecorderFor("podconfig-operator"),
+		ResyncInterval: resyncInterval,
+		LinkEvents:     linkEvents,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PodConfig")
+		os.Exit(1)
+	}
+
+	if err = (&podconfigcontroller.PodConfigQuotaReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("PodConfig"),
+		Log:    ctrl.Log.WithName("controllers").WithName("PodConfigQuota"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "PodConfig")
+		setupLog.Error(err, "unable to create controller", "controller", "PodConfigQuota")
+		os.Exit(1)
+	}
+
+	if err = (&podconfigcontroller.PodCommandReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("PodCommand"),
+		Scheme:        mgr.GetScheme(),
+		NodeName:      nodeName,
+		ClusterReader: clusterReader,
+		Recorder:      mgr.GetEventRecorderFor("podconfig-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PodCommand")
+		os.Exit(1)
+	}
+
+	if err = (&podconfigcontroller.PacketCaptureReconciler{
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("PacketCapture"),
+		Scheme:      mgr.GetScheme(),
+		NodeName:    nodeName,
+		Recorder:    mgr.GetEventRecorderFor("podconfig-operator"),
+		MaxCaptures: maxCaptures,
+		ByteBudget:  byteBudget.Value(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PacketCapture")
 		os.Exit(1)
 	}
+
+	if err = mgr.Add(&podconfigcontroller.Sweeper{
+		Client:     mgr.GetClient(),
+		Log:        ctrl.Log.WithName("sweeper"),
+		Recorder:   mgr.GetEventRecorderFor("podconfig-sweeper"),
+		NodeName:   nodeName,
+		Interval:   sweepInterval,
+		Namespaces: namespaces,
+	}); err != nil {
+		setupLog.Error(err, "unable to add sweeper")
+		os.Exit(1)
+	}
+
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		webhookOptions := podconfigv1alpha1.WebhookOptions{
+			AllowedBridges:      splitList(allowedBridges),
+			AllowedHostPaths:    splitList(allowedHostPaths),
+			AllowedCapabilities: splitList(allowedCapabilities),
+			SubnetPrefix:        subnetPrefix,
+			ClusterReader:       clusterReader,
+		}
+		if cidrPool != "" {
+			_, webhookOptions.CIDRPool, err = net.ParseCIDR(cidrPool)
+			if err != nil {
+				setupLog.Error(err, "invalid cidr pool", "cidr-pool", cidrPool)
+				os.Exit(1)
+			}
+		}
+		if err = (&podconfigv1alpha1.PodConfig{}).SetupWebhookWithManager(mgr, webhookOptions); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PodConfig")
+			os.Exit(1)
+		}
+		if err = (&podconfigv1alpha1.PodCommand{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PodCommand")
+			os.Exit(1)
+		}
+		if err = (&podconfigv1alpha1.PacketCapture{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PacketCapture")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -84,3 +249,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// Splits a comma separated flag value ignoring empty items
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}